@@ -29,7 +29,7 @@ type VarianceItem = {
   key: string;
   type: string;
   currency: string;
-  amounts_by_source?: Array<{ source: string; amount_cents: number }>;
+  amounts_by_source?: Array<{ source: string; amount_minor: number }>;
   missing_sources?: string[];
 };
 
@@ -204,7 +204,7 @@ export default function ImportResultsPage() {
                   <div>
                     Amounts:{' '}
                     {item.amounts_by_source
-                      .map((entry) => `${entry.source}: ${entry.amount_cents}`)
+                      .map((entry) => `${entry.source}: ${entry.amount_minor}`)
                       .join(', ')}
                   </div>
                 ) : null}
@@ -6,7 +6,7 @@ type VarianceItem = {
   key: string;
   type: string;
   currency: string;
-  amounts_by_source?: Array<{ source: string; amount_cents: number }>;
+  amounts_by_source?: Array<{ source: string; amount_minor: number }>;
   missing_sources?: string[];
 };
 
@@ -58,7 +58,7 @@ export default function ViewVariancesPage() {
                   <div>
                     Amounts:{' '}
                     {item.amounts_by_source
-                      .map((entry) => `${entry.source}: ${entry.amount_cents}`)
+                      .map((entry) => `${entry.source}: ${entry.amount_minor}`)
                       .join(', ')}
                   </div>
                 ) : null}
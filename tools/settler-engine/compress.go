@@ -0,0 +1,195 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte{'P', 'K', 0x03, 0x04}
+)
+
+// compressionForName reports the compression implied by a file name's
+// extension: gzip, zstd, zip, or "" for none.
+func compressionForName(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz", ".gzip":
+		return "gzip"
+	case ".zst", ".zstd":
+		return "zstd"
+	case ".zip":
+		return "zip"
+	}
+	return ""
+}
+
+// decompressedName drops a gzip or zstd extension, so "daily.csv.gz" is
+// detected as CSV.
+func decompressedName(name string) string {
+	switch compressionForName(name) {
+	case "gzip", "zstd":
+		return strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name
+}
+
+// inputCompression reports how an input file is compressed. The extension
+// decides first; otherwise the leading bytes are checked so compressed
+// exports are read whatever they are called. Workbooks are zip files too and
+// are never treated as archives.
+func inputCompression(file io.ReaderAt, name string, format string) (string, error) {
+	if compression := compressionForName(name); compression != "" {
+		return compression, nil
+	}
+	magic := make([]byte, len(zstdMagic))
+	count, err := file.ReadAt(magic, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	magic = magic[:count]
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return "gzip", nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		return "zstd", nil
+	case bytes.HasPrefix(magic, zipMagic) && format != "xlsx" && !(format == "auto" && detectFormat(name) == "xlsx"):
+		return "zip", nil
+	}
+	return "", nil
+}
+
+// decompress wraps a gzip or zstd stream in a reader of its contents.
+func decompress(reader io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case "gzip":
+		stream, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("open gzip stream: %w", err)
+		}
+		return stream, nil
+	case "zstd":
+		stream, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("open zstd stream: %w", err)
+		}
+		return stream.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported compression: %s", compression)
+}
+
+func validateArchiveMembers(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid archive_members pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// archiveMemberSelected reports whether a member matches one of the
+// patterns, compared against both its full name and its base name. With no
+// patterns only members whose extension names an input format are read, so
+// a README or manifest shipped alongside the data is left alone.
+func archiveMemberSelected(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		_, known := formatForExtension(name)
+		return known
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, path.Base(name)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// readArchive streams the records of each selected zip member in archive
+// order. Members may themselves be gzip or zstd compressed, and each row is
+// tagged with the member it came from.
+func readArchive(reader io.ReaderAt, size int64, format string, settings FieldMapping, fn func(inputRow) error, warn func(string)) error {
+	archive, err := zip.NewReader(reader, size)
+	if err != nil {
+		return fmt.Errorf("open zip archive: %w", err)
+	}
+	selected := 0
+	for _, member := range archive.File {
+		if member.FileInfo().IsDir() || !archiveMemberSelected(member.Name, settings.ArchiveMembers) {
+			continue
+		}
+		selected++
+		if err := readArchiveMember(member, format, settings, fn, warn); err != nil {
+			return fmt.Errorf("zip member %s: %w", member.Name, err)
+		}
+	}
+	if selected == 0 {
+		if len(settings.ArchiveMembers) > 0 {
+			return fmt.Errorf("zip archive has no members matching %s", strings.Join(settings.ArchiveMembers, ", "))
+		}
+		return errors.New("zip archive has no members with a known data format; set archive_members to choose them")
+	}
+	return nil
+}
+
+func readArchiveMember(member *zip.File, format string, settings FieldMapping, fn func(inputRow) error, warn func(string)) error {
+	contents, err := member.Open()
+	if err != nil {
+		return err
+	}
+	defer contents.Close()
+
+	var stream io.Reader = contents
+	switch compression := compressionForName(member.Name); compression {
+	case "zip":
+		return errors.New("nested zip archives are not supported")
+	case "gzip", "zstd":
+		decompressed, err := decompress(contents, compression)
+		if err != nil {
+			return err
+		}
+		defer decompressed.Close()
+		stream = decompressed
+	}
+	return readRecords(stream, decompressedName(member.Name), format, settings, func(row inputRow) error {
+		row.member = member.Name
+		return fn(row)
+	}, warn)
+}
+
+// readXLSXStream reads a workbook from a plain file in place. A workbook that
+// arrives compressed or inside an archive is unpacked to a temporary file
+// first, since reading its parts needs random access.
+func readXLSXStream(reader io.Reader, options *XLSXOptions, fn func(inputRow) error) error {
+	if file, ok := reader.(*os.File); ok {
+		info, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("stat input file %s: %w", file.Name(), err)
+		}
+		return readXLSX(file, info.Size(), options, fn)
+	}
+	spill, err := os.CreateTemp("", "settler-engine-xlsx-")
+	if err != nil {
+		return fmt.Errorf("create xlsx spill: %w", err)
+	}
+	defer os.Remove(spill.Name())
+	defer spill.Close()
+	size, err := io.Copy(spill, reader)
+	if err != nil {
+		return fmt.Errorf("read xlsx: %w", err)
+	}
+	return readXLSX(spill, size, options, fn)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func gzipBytes(t *testing.T, content []byte) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(content); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return buffer.Bytes()
+}
+
+func TestCompressedInputs(t *testing.T) {
+	fixtureDir := filepath.Join("fixtures", "basic")
+	ledger, err := os.ReadFile(filepath.Join(fixtureDir, "source_a.csv"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+	processor, err := os.ReadFile(filepath.Join(fixtureDir, "source_b.json"))
+	if err != nil {
+		t.Fatalf("read fixture: %v", err)
+	}
+
+	inputDir := t.TempDir()
+	ledgerPath := filepath.Join(inputDir, "ledger.csv.gz")
+	if err := os.WriteFile(ledgerPath, gzipBytes(t, ledger), 0o644); err != nil {
+		t.Fatalf("write ledger: %v", err)
+	}
+	var archive bytes.Buffer
+	zipWriter := zip.NewWriter(&archive)
+	for name, content := range map[string][]byte{
+		"README.txt":                   []byte("nightly export\n"),
+		"exports/processor.json.gz":    gzipBytes(t, processor),
+		"exports/processor-empty.json": []byte("[]"),
+	} {
+		writer, err := zipWriter.Create(name)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		if _, err := writer.Write(content); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatalf("close zip: %v", err)
+	}
+	bundlePath := filepath.Join(inputDir, "bundle.zip")
+	if err := os.WriteFile(bundlePath, archive.Bytes(), 0o644); err != nil {
+		t.Fatalf("write bundle: %v", err)
+	}
+
+	output, outputDir := runFixtureWith(t, "basic", func(input *EngineInput) {
+		input.Sources = []SourceInput{
+			{Name: "source_a", Files: []string{ledgerPath}},
+			{Name: "source_b", Files: []string{bundlePath}, Mapping: &FieldMapping{ArchiveMembers: []string{"*.json*"}}},
+		}
+		input.InputFiles = nil
+	})
+	if output.VarianceSummary.CountsByType["missing_record"] != 2 || output.VarianceSummary.CountsByType["amount_mismatch"] != 1 {
+		t.Fatalf("unexpected variance counts: %+v", output.VarianceSummary.CountsByType)
+	}
+	if len(output.EvidenceManifest.Inputs) != 2 {
+		t.Fatalf("inputs not hashed into manifest: %+v", output.EvidenceManifest.Inputs)
+	}
+	bundleHash, err := hashFile(bundlePath)
+	if err != nil {
+		t.Fatalf("hash bundle: %v", err)
+	}
+	if hashed := output.EvidenceManifest.Inputs[1]; !strings.HasSuffix(hashed.Path, "bundle.zip") || hashed.SHA256 != bundleHash {
+		t.Fatalf("manifest should hash the original archive: %+v", hashed)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "evidence", "normalized.jsonl"))
+	if err != nil {
+		t.Fatalf("read normalized records: %v", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		var record NormalizedRecord
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("parse normalized record: %v", err)
+		}
+		if record.Source == "source_b" && !strings.HasSuffix(record.File, "bundle.zip/exports/processor.json.gz") {
+			t.Fatalf("archive member not recorded on record: %+v", record)
+		}
+	}
+
+	// Without archive_members only members named like data are read, so the
+	// README is not parsed as CSV.
+	output, _ = runFixtureWith(t, "basic", func(input *EngineInput) {
+		input.Sources = []SourceInput{
+			{Name: "source_a", Files: []string{ledgerPath}},
+			{Name: "source_b", Files: []string{bundlePath}},
+		}
+		input.InputFiles = nil
+	})
+	if output.NormalizationSummary.RecordsSkipped != 0 || output.VarianceSummary.CountsByType["amount_mismatch"] != 1 {
+		t.Fatalf("default member selection should skip the README: %+v %+v", output.NormalizationSummary, output.VarianceSummary.CountsByType)
+	}
+
+	inputPath := prepareFixture(t, "basic", func(input *EngineInput) {
+		input.Sources = []SourceInput{
+			{Name: "source_a", Files: []string{ledgerPath}},
+			{Name: "source_b", Files: []string{bundlePath}, Mapping: &FieldMapping{ArchiveMembers: []string{"*.parquet"}}},
+		}
+		input.InputFiles = nil
+	})
+	if _, err := RunEngine(inputPath); err == nil || !strings.Contains(err.Error(), "no members matching *.parquet") {
+		t.Fatalf("expected unmatched member error, got %v", err)
+	}
+}
+
+func TestCompressedXLSXInput(t *testing.T) {
+	sheet := `<row r="1"><c r="A1" t="inlineStr"><is><t>id</t></is></c><c r="B1" t="inlineStr"><is><t>amount</t></is></c></row>` +
+		`<row r="2"><c r="A2"><v>7</v></c><c r="B2"><v>12.5</v></c></row>`
+	styles := `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><cellXfs count="1"><xf numFmtId="0"/></cellXfs></styleSheet>`
+	sharedStrings := `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"></sst>`
+	workbook := buildXLSX(t, map[string]string{"Summary": sheet}, sharedStrings, styles)
+	data, err := io.ReadAll(workbook)
+	if err != nil {
+		t.Fatalf("read workbook: %v", err)
+	}
+	path := writeTempFile(t, "book.xlsx.gz", string(gzipBytes(t, data)))
+
+	rows := make([]inputRow, 0)
+	err = streamRecords(path, "auto", FieldMapping{}, func(row inputRow) error {
+		rows = append(rows, row)
+		return nil
+	}, func(string) {})
+	if err != nil {
+		t.Fatalf("stream compressed workbook: %v", err)
+	}
+	if len(rows) != 1 || rows[0].fields["id"] != "7" || rows[0].fields["amount"] != "12.5" {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+}
+
+func TestInputCompressionMagic(t *testing.T) {
+	var buffer bytes.Buffer
+	encoder, err := zstd.NewWriter(&buffer)
+	if err != nil {
+		t.Fatalf("zstd: %v", err)
+	}
+	if _, err := encoder.Write([]byte("id,amount\n1,2.50\n")); err != nil {
+		t.Fatalf("zstd: %v", err)
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatalf("zstd: %v", err)
+	}
+	// A zstd export saved under a plain .csv name is still detected.
+	path := writeTempFile(t, "export.csv", buffer.String())
+
+	rows := make([]inputRow, 0)
+	err = streamRecords(path, "auto", FieldMapping{}, func(row inputRow) error {
+		rows = append(rows, row)
+		return nil
+	}, func(string) {})
+	if err != nil {
+		t.Fatalf("stream zstd input: %v", err)
+	}
+	if len(rows) != 1 || rows[0].fields["amount"] != "2.50" || rows[0].line != 2 {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+
+	workbook := bytes.NewReader(append(append([]byte{}, zipMagic...), 0, 0))
+	if compression, _ := inputCompression(workbook, "report.xlsx", "auto"); compression != "" {
+		t.Fatalf("workbooks must not be read as archives, got %q", compression)
+	}
+	if compression, _ := inputCompression(workbook, "bundle.bin", "auto"); compression != "zip" {
+		t.Fatalf("zip magic not detected, got %q", compression)
+	}
+	if got := detectFormat("daily.JSONL.gz"); got != "jsonl" {
+		t.Fatalf("detectFormat ignored compression extension: %s", got)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configDocument is a ruleset or mapping file read as JSON or, for .yaml and
+// .yml paths, YAML. Decoding errors are reported with line and column.
+type configDocument struct {
+	data []byte
+	node *yaml.Node
+}
+
+func readConfigDocument(path string) (*configDocument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc := &configDocument{data: data}
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".yaml" || ext == ".yml" {
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return nil, fmt.Errorf("parse yaml: %w", positionedYAMLError(err, nil))
+		}
+		doc.node = &node
+	}
+	return doc, nil
+}
+
+func (doc *configDocument) format() string {
+	if doc.node != nil {
+		return "yaml"
+	}
+	return "json"
+}
+
+// keys returns the field names present in the object found by following path
+// from the document root.
+func (doc *configDocument) keys(path ...string) (map[string]bool, error) {
+	keys := map[string]bool{}
+	if doc.node != nil {
+		current := doc.node
+		if current.Kind == yaml.DocumentNode && len(current.Content) > 0 {
+			current = current.Content[0]
+		}
+		for _, name := range path {
+			current = yamlMappingValue(current, name)
+			if current == nil {
+				return keys, nil
+			}
+		}
+		if current.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("line %d, column %d: expected a mapping", current.Line, current.Column)
+		}
+		for index := 0; index+1 < len(current.Content); index += 2 {
+			keys[current.Content[index].Value] = true
+		}
+		return keys, nil
+	}
+
+	raw := json.RawMessage(doc.data)
+	for _, name := range path {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, positionedJSONError(doc.data, err, -1)
+		}
+		next, ok := fields[name]
+		if !ok {
+			return keys, nil
+		}
+		raw = next
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, positionedJSONError(doc.data, err, -1)
+	}
+	for name := range fields {
+		keys[name] = true
+	}
+	return keys, nil
+}
+
+// decode populates target from the document. With strict set, fields that do
+// not exist on target are rejected.
+func (doc *configDocument) decode(target any, strict bool) error {
+	if doc.node != nil {
+		decoder := yaml.NewDecoder(bytes.NewReader(doc.data))
+		decoder.KnownFields(strict)
+		if err := decoder.Decode(target); err != nil {
+			return positionedYAMLError(err, doc.node)
+		}
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(doc.data))
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(target); err != nil {
+		return positionedJSONError(doc.data, err, decoder.InputOffset())
+	}
+	return nil
+}
+
+func yamlMappingValue(node *yaml.Node, name string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for index := 0; index+1 < len(node.Content); index += 2 {
+		if node.Content[index].Value == name {
+			return node.Content[index+1]
+		}
+	}
+	return nil
+}
+
+// positionedJSONError prefixes a JSON decoding error with the line and column
+// of the offending byte. Errors that carry no offset of their own use
+// fallbackOffset, the decoder position, when it is known.
+func positionedJSONError(data []byte, err error, fallbackOffset int64) error {
+	offset := fallbackOffset
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) {
+		// The offset counts the offending byte itself.
+		offset = syntaxErr.Offset - 1
+	} else if errors.As(err, &typeErr) {
+		offset = scalarStart(data, typeErr.Offset)
+	}
+	if offset < 0 {
+		return err
+	}
+	line, column := lineColumn(data, offset)
+	return fmt.Errorf("line %d, column %d: %w", line, column, err)
+}
+
+// scalarStart walks back from the end of a decoded string, number or literal
+// to where it begins. Other values are left at end.
+func scalarStart(data []byte, end int64) int64 {
+	if end <= 0 || end > int64(len(data)) {
+		return end
+	}
+	index := end - 1
+	if data[index] == '"' {
+		for index--; index >= 0; index-- {
+			if data[index] == '"' && (index == 0 || data[index-1] != '\\') {
+				return index
+			}
+		}
+		return end
+	}
+	for index >= 0 && !strings.ContainsRune(" \t\r\n,:[]{}", rune(data[index])) {
+		index--
+	}
+	return index + 1
+}
+
+func lineColumn(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line, column := 1, 1
+	for _, char := range data[:offset] {
+		if char == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return line, column
+}
+
+var (
+	yamlLinePattern  = regexp.MustCompile(`line (\d+): `)
+	yamlTokenPattern = regexp.MustCompile("`([^`]*)`|field (\\S+) not found")
+)
+
+// positionedYAMLError rewrites yaml.v3 errors, which only carry line numbers,
+// into "line L, column C" form by locating the node the message refers to on
+// that line. YAML syntax errors are reported before a node tree exists and
+// keep the line only.
+func positionedYAMLError(err error, root *yaml.Node) error {
+	messages := []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+
+	for index, message := range messages {
+		match := yamlLinePattern.FindStringSubmatchIndex(message)
+		if match == nil {
+			continue
+		}
+		line, _ := strconv.Atoi(message[match[2]:match[3]])
+		token := ""
+		if tokenMatch := yamlTokenPattern.FindStringSubmatch(message); tokenMatch != nil {
+			token = tokenMatch[1] + tokenMatch[2]
+		}
+		if column := yamlColumn(root, line, token); column > 0 {
+			messages[index] = fmt.Sprintf("%sline %d, column %d: %s", message[:match[0]], line, column, message[match[1]:])
+		}
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
+
+// yamlColumn returns the column of the node on line whose value is token, or
+// of the leftmost node on that line when no node matches.
+func yamlColumn(root *yaml.Node, line int, token string) int {
+	first, matched := 0, 0
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		if node == nil {
+			return
+		}
+		if node.Line == line && node.Kind != yaml.DocumentNode {
+			if first == 0 || node.Column < first {
+				first = node.Column
+			}
+			if token != "" && node.Kind == yaml.ScalarNode && node.Value == token && matched == 0 {
+				matched = node.Column
+			}
+		}
+		for _, child := range node.Content {
+			walk(child)
+		}
+	}
+	walk(root)
+	if matched > 0 {
+		return matched
+	}
+	return first
+}
@@ -0,0 +1,50 @@
+package main
+
+import "strings"
+
+// iso4217MinorUnits lists ISO 4217 currencies whose minor unit exponent is not
+// the default of 2.
+var iso4217MinorUnits = map[string]int{
+	"BHD": 3,
+	"BIF": 0,
+	"CLF": 4,
+	"CLP": 0,
+	"DJF": 0,
+	"GNF": 0,
+	"IQD": 3,
+	"ISK": 0,
+	"JOD": 3,
+	"JPY": 0,
+	"KMF": 0,
+	"KRW": 0,
+	"KWD": 3,
+	"LYD": 3,
+	"OMR": 3,
+	"PYG": 0,
+	"RWF": 0,
+	"TND": 3,
+	"UGX": 0,
+	"UYI": 0,
+	"UYW": 4,
+	"VND": 0,
+	"VUV": 0,
+	"XAF": 0,
+	"XOF": 0,
+	"XPF": 0,
+}
+
+const defaultMinorUnits = 2
+
+// minorUnitsFor returns the number of decimal places used for the currency's
+// minor unit. Ruleset overrides take precedence over the ISO 4217 table, and
+// unknown or empty currencies fall back to two decimals.
+func minorUnitsFor(currency string, overrides map[string]int) int {
+	code := strings.ToUpper(strings.TrimSpace(currency))
+	if exponent, ok := overrides[code]; ok {
+		return exponent
+	}
+	if exponent, ok := iso4217MinorUnits[code]; ok {
+		return exponent
+	}
+	return defaultMinorUnits
+}
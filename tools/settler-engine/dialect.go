@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// csvEncodings maps accepted encoding names to the canonical name stored on
+// the dialect.
+var csvEncodings = map[string]string{
+	"":             "utf-8",
+	"utf-8":        "utf-8",
+	"utf8":         "utf-8",
+	"latin-1":      "latin-1",
+	"latin1":       "latin-1",
+	"iso-8859-1":   "latin-1",
+	"windows-1252": "windows-1252",
+	"cp1252":       "windows-1252",
+}
+
+// windows1252High holds the characters Windows-1252 assigns to bytes 0x80
+// through 0x9F, where it differs from Latin-1. Unassigned bytes map to the
+// matching C1 control character, as Latin-1 does.
+var windows1252High = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+}
+
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+func validateCSVDialect(dialect *CSVDialect) error {
+	if dialect == nil {
+		return nil
+	}
+	encoding, ok := csvEncodings[strings.ToLower(strings.TrimSpace(dialect.Encoding))]
+	if !ok {
+		return fmt.Errorf("unsupported csv encoding: %s", dialect.Encoding)
+	}
+	dialect.Encoding = encoding
+	if dialect.Delimiter != "" && utf8.RuneCountInString(dialect.Delimiter) != 1 {
+		return fmt.Errorf("csv delimiter must be a single character: %q", dialect.Delimiter)
+	}
+	if dialect.Comment != "" && utf8.RuneCountInString(dialect.Comment) != 1 {
+		return fmt.Errorf("csv comment must be a single character: %q", dialect.Comment)
+	}
+	if dialect.Delimiter != "" && dialect.Delimiter == dialect.Comment {
+		return errors.New("csv comment must differ from delimiter")
+	}
+	if dialect.SkipRows < 0 {
+		return errors.New("csv skip_rows must not be negative")
+	}
+	if dialect.HeaderRow < 0 {
+		return errors.New("csv header_row must not be negative")
+	}
+	if dialect.NoHeader && dialect.HeaderRow > 0 {
+		return errors.New("csv header_row cannot be set with no_header")
+	}
+	return nil
+}
+
+// csvInput applies a dialect's encoding and skip_rows to a raw CSV stream and
+// drops a leading UTF-8 byte order mark.
+func csvInput(reader io.Reader, dialect *CSVDialect) (io.Reader, error) {
+	buffered := bufio.NewReader(reader)
+	var decoded io.Reader = buffered
+	switch dialect.Encoding {
+	case "latin-1":
+		decoded = &singleByteDecoder{source: buffered}
+	case "windows-1252":
+		decoded = &singleByteDecoder{source: buffered, high: &windows1252High}
+	default:
+		if prefix, _ := buffered.Peek(len(utf8BOM)); string(prefix) == string(utf8BOM) {
+			if _, err := buffered.Discard(len(utf8BOM)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	lines := bufio.NewReader(decoded)
+	for skipped := 0; skipped < dialect.SkipRows; skipped++ {
+		if _, err := lines.ReadString('\n'); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+	}
+	return lines, nil
+}
+
+// rawRecorder keeps the text read through it since the last discard, so a
+// CSV record or statement entry can be reported exactly as it appeared in
+// the input.
+type rawRecorder struct {
+	reader io.Reader
+	buffer []byte
+	base   int64
+}
+
+func (recorder *rawRecorder) Read(p []byte) (int, error) {
+	count, err := recorder.reader.Read(p)
+	recorder.buffer = append(recorder.buffer, p[:count]...)
+	return count, err
+}
+
+// record returns the record text between two input offsets, without the
+// blank and comment lines the csv reader skips before a record or the line
+// ending after it.
+func (recorder *rawRecorder) record(start int64, end int64, comment rune) string {
+	text := recorder.text(start, end)
+	for {
+		newline := strings.IndexByte(text, '\n')
+		if newline < 0 {
+			break
+		}
+		line := strings.TrimRight(text[:newline], "\r")
+		if line != "" && (comment == 0 || !strings.HasPrefix(line, string(comment))) {
+			break
+		}
+		text = text[newline+1:]
+	}
+	return strings.TrimRight(text, "\r\n")
+}
+
+// text returns the input between two offsets.
+func (recorder *rawRecorder) text(start int64, end int64) string {
+	return string(recorder.buffer[start-recorder.base : end-recorder.base])
+}
+
+// discard drops the text before offset.
+func (recorder *rawRecorder) discard(offset int64) {
+	recorder.buffer = append(recorder.buffer[:0], recorder.buffer[offset-recorder.base:]...)
+	recorder.base = offset
+}
+
+// singleByteDecoder converts a single-byte encoding to UTF-8. Bytes map to
+// the code point of the same value, except 0x80 through 0x9F when high is set.
+type singleByteDecoder struct {
+	source  *bufio.Reader
+	high    *[32]rune
+	pending []byte
+}
+
+func (decoder *singleByteDecoder) Read(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		if len(decoder.pending) > 0 {
+			copied := copy(p[written:], decoder.pending)
+			decoder.pending = decoder.pending[copied:]
+			written += copied
+			continue
+		}
+		char, err := decoder.source.ReadByte()
+		if err != nil {
+			if written > 0 {
+				return written, nil
+			}
+			return 0, err
+		}
+		if char < utf8.RuneSelf {
+			p[written] = char
+			written++
+			continue
+		}
+		value := rune(char)
+		if decoder.high != nil && char < 0xa0 {
+			value = decoder.high[char-0x80]
+		}
+		decoder.pending = utf8.AppendRune(decoder.pending[:0], value)
+	}
+	return written, nil
+}
+
+func validateFixedWidthLayout(layout *FixedWidthLayout) error {
+	if layout == nil {
+		return nil
+	}
+	encoding, ok := csvEncodings[strings.ToLower(strings.TrimSpace(layout.Encoding))]
+	if !ok {
+		return fmt.Errorf("unsupported fixed_width encoding: %s", layout.Encoding)
+	}
+	layout.Encoding = encoding
+	if len(layout.Columns) == 0 {
+		return errors.New("fixed_width layout needs at least one column")
+	}
+	if layout.SkipRows < 0 {
+		return errors.New("fixed_width skip_rows must not be negative")
+	}
+	seen := make(map[string]bool, len(layout.Columns))
+	for _, column := range layout.Columns {
+		if column.Name == "" {
+			return errors.New("fixed_width column needs a name")
+		}
+		if seen[column.Name] {
+			return fmt.Errorf("duplicate fixed_width column: %s", column.Name)
+		}
+		seen[column.Name] = true
+		if column.Start < 1 || column.Width < 1 {
+			return fmt.Errorf("fixed_width column %s needs a start and width of at least 1", column.Name)
+		}
+	}
+	return nil
+}
+
+// readFixedWidth streams the lines of a fixed-width file as records keyed by
+// the layout's column names. Columns are counted in characters after
+// decoding, values are trimmed, and blank lines are skipped.
+func readFixedWidth(reader io.Reader, layout *FixedWidthLayout, fn func(inputRow) error) error {
+	input, err := csvInput(reader, &CSVDialect{Encoding: layout.Encoding, SkipRows: layout.SkipRows})
+	if err != nil {
+		return fmt.Errorf("read fixed_width: %w", err)
+	}
+	width := 0
+	for _, column := range layout.Columns {
+		width = max(width, column.Start-1+column.Width)
+	}
+	lines := bufio.NewReader(input)
+	for line := layout.SkipRows + 1; ; line++ {
+		text, err := lines.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("read fixed_width: %w", err)
+		}
+		text = strings.TrimRight(text, "\r\n")
+		if strings.TrimSpace(text) != "" {
+			raw := text
+			chars := []rune(text)
+			fields := make(map[string]string, len(layout.Columns))
+			for _, column := range layout.Columns {
+				start := min(column.Start-1, len(chars))
+				end := min(start+column.Width, len(chars))
+				fields[column.Name] = strings.TrimSpace(string(chars[start:end]))
+			}
+			// Exports often trim trailing blanks, so a short line reads as
+			// padded; only text past the layout is a width mismatch.
+			row := inputRow{fields: fields, line: line, raw: func() string { return raw }}
+			if len(chars) > width && strings.TrimSpace(string(chars[width:])) != "" {
+				row.reject = fmt.Sprintf("text past the %d characters of the layout", width)
+			}
+			if fnErr := fn(row); fnErr != nil {
+				return fnErr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+}
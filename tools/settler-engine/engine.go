@@ -2,23 +2,31 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/sha256"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
+// SchemaVersion versions the output and evidence formats. 2.0.0 renamed the
+// cent-based amount fields to minor units (amount_cents became amount_minor).
 const (
 	ToolVersion   = "0.1.0"
-	SchemaVersion = "1.0.0"
+	SchemaVersion = "2.0.0"
 )
 
 func RunEngine(inputPath string) (*EngineOutput, error) {
@@ -32,18 +40,28 @@ func RunEngine(inputPath string) (*EngineOutput, error) {
 		return nil, fmt.Errorf("parse input json: %w", err)
 	}
 
+	// Settings as written, before defaults fill them in, to tell a ruleset
+	// conflict apart from a setting that was left out.
+	declared := input
 	if err := validateInput(&input); err != nil {
 		return nil, err
 	}
 
 	baseDir := filepath.Dir(inputPath)
 	input.InputFiles = resolvePaths(baseDir, input.InputFiles)
+	for index := range input.Sources {
+		input.Sources[index].Files = resolvePaths(baseDir, input.Sources[index].Files)
+	}
 	input.RulesetPath = resolvePath(baseDir, input.RulesetPath)
 	if input.MappingConfigPath != nil && *input.MappingConfigPath != "" {
 		resolved := resolvePath(baseDir, *input.MappingConfigPath)
 		input.MappingConfigPath = &resolved
 	}
 	input.OutputDir = resolvePath(baseDir, input.OutputDir)
+	if input.FXRatesPath != nil && *input.FXRatesPath != "" {
+		resolved := resolvePath(baseDir, *input.FXRatesPath)
+		input.FXRatesPath = &resolved
+	}
 
 	ruleset, err := loadRuleset(input.RulesetPath)
 	if err != nil {
@@ -55,11 +73,32 @@ func RunEngine(inputPath string) (*EngineOutput, error) {
 		return nil, err
 	}
 
-	sources := resolveSources(ruleset, input.InputFiles)
+	var fxTable *FXTable
+	reportingCurrency := ""
+	if input.FXRatesPath != nil && *input.FXRatesPath != "" {
+		fxTable, err = loadFXRates(*input.FXRatesPath)
+		if err != nil {
+			return nil, err
+		}
+		reportingCurrency = strings.ToUpper(*input.ReportingCurrency)
+	}
+
+	sources, sourceFiles, err := resolveSourceFiles(ruleset, &input)
+	if err != nil {
+		return nil, err
+	}
+	mappingWarnings, err := bindSourceMappings(mapping, input.Sources, sources)
+	if err != nil {
+		return nil, err
+	}
 	if len(sources) == 0 {
 		return nil, errors.New("ruleset must define at least one source")
 	}
 
+	if err := applyRulesetSettings(ruleset, &input, declared); err != nil {
+		return nil, err
+	}
+
 	location, err := time.LoadLocation(input.Timezone)
 	if err != nil {
 		return nil, fmt.Errorf("invalid timezone: %w", err)
@@ -89,50 +128,100 @@ func RunEngine(inputPath string) (*EngineOutput, error) {
 		return nil, fmt.Errorf("write log: %w", err)
 	}
 
-	normalizedRecords := make([]NormalizedRecord, 0)
-	warnings := make([]string, 0)
+	sorter := newRecordSorter(input.MaxRecordsInMemory)
+	defer sorter.close()
+	rounding := roundingRule{Mode: input.RoundingMode, Increment: input.RoundingIncrement}
+	compareFields := comparedFields(ruleset)
+	warnings := append(make([]string, 0), mappingWarnings...)
+	parseWarnings := make([]ParseWarning, 0)
 	recordsProcessed := 0
 	recordsSkipped := 0
 
-	for index, path := range input.InputFiles {
-		source := sources[index]
-		format := input.InputFormat
-		if format == "auto" {
-			format = detectFormat(path)
-		}
+	rejectedPath := filepath.Join(evidenceDir, "rejected.jsonl")
+	rejectedWriter, err := newJSONLinesWriter(rejectedPath)
+	if err != nil {
+		return nil, err
+	}
 
-		records, err := loadRecords(path, format)
-		if err != nil {
-			return nil, err
+	for _, sourceFile := range sourceFiles {
+		source, path := sourceFile.source, sourceFile.path
+		inputFile := relativePath(baseDir, path)
+		// Rows read from an archive are located by the member within it.
+		origin := func(row inputRow) string {
+			if row.member != "" {
+				return inputFile + "/" + row.member
+			}
+			return inputFile
+		}
+		reject := func(row inputRow, reason string) error {
+			recordsSkipped++
+			file := origin(row)
+			if input.Strict {
+				if row.line > 0 {
+					return fmt.Errorf("strict mode: %s line %d rejected: %s", file, row.line, reason)
+				}
+				return fmt.Errorf("strict mode: %s row rejected: %s", file, reason)
+			}
+			return rejectedWriter.write(RejectedRow{Source: source, File: file, Line: row.line, Raw: row.raw(), Reason: reason})
 		}
 
-		for _, record := range records {
-			mapped := mapRecord(record, source, ruleset, mapping)
+		err := streamRecords(path, sourceFile.format, mapping.Sources[source], func(row inputRow) error {
+			if row.reject != "" {
+				warnings = append(warnings, fmt.Sprintf("%s: line %d: %s", source, row.line, row.reject))
+				return reject(row, row.reject)
+			}
+			mapped := mapRecord(row.fields, source, ruleset, mapping)
 			key, keyWarnings := buildKey(mapped, ruleset.KeyFields)
 			if len(keyWarnings) > 0 {
 				warnings = append(warnings, keyWarnings...)
 			}
 			if key == "" {
-				recordsSkipped++
-				continue
-			}
-
-			amountValue := mapped[ruleset.AmountField]
-			amountCents, amountWarning := parseAmount(amountValue, input.RoundingMode)
-			if amountWarning != "" {
-				warnings = append(warnings, fmt.Sprintf("%s: %s", source, amountWarning))
+				return reject(row, strings.Join(keyWarnings, "; "))
 			}
 
 			currency := mapped[ruleset.CurrencyField]
 			if currency == "" && input.Currency != nil {
 				currency = *input.Currency
 			}
+			currency = strings.ToUpper(strings.TrimSpace(currency))
+			exponent := minorUnitsFor(currency, ruleset.MinorUnits)
+
+			amountValue := mapped[ruleset.AmountField]
+			amountText, amountWarning := normalizeAmountText(amountValue, mapping.Sources[source].AmountFormat)
+			var amountMinor int64
+			var rounded bool
+			if amountWarning == "" {
+				amountMinor, rounded, amountWarning = parseAmount(amountText, rounding, exponent)
+			}
+			var roundingDecision *RoundingDecision
+			if rounded {
+				roundingDecision = &RoundingDecision{
+					Mode:                rounding.Mode,
+					IncrementMinorUnits: rounding.Increment,
+					RawValue:            amountValue,
+				}
+			}
+			if amountWarning != "" {
+				warnings = append(warnings, fmt.Sprintf("%s: %s", source, amountWarning))
+				parseWarnings = append(parseWarnings, ParseWarning{
+					Source:   source,
+					RecordID: mapped["id"],
+					Field:    ruleset.AmountField,
+					RawValue: amountValue,
+					Message:  amountWarning,
+				})
+			}
 
 			timestamp := mapped[ruleset.TimestampField]
+			// rateDate is the record's calendar date in the run timezone,
+			// set only when the timestamp parsed.
+			rateDate := ""
 			if timestamp != "" {
 				normalizedTimestamp, tsWarning := normalizeTimestamp(timestamp, location)
 				if tsWarning != "" {
 					warnings = append(warnings, fmt.Sprintf("%s: %s", source, tsWarning))
+				} else {
+					rateDate = normalizedTimestamp[:len("2006-01-02")]
 				}
 				timestamp = normalizedTimestamp
 			}
@@ -140,41 +229,158 @@ func RunEngine(inputPath string) (*EngineOutput, error) {
 			recordID := mapped["id"]
 			account := mapped["account"]
 
-			normalizedRecords = append(normalizedRecords, NormalizedRecord{
-				Source:      source,
-				Key:         key,
-				ID:          recordID,
-				Account:     account,
-				AmountCents: amountCents,
-				Currency:    currency,
-				Timestamp:   timestamp,
+			var originalAmount *int64
+			var fxRounding *RoundingDecision
+			originalCurrency := ""
+			fxRateText := ""
+			if fxTable != nil && currency != "" && !strings.EqualFold(currency, reportingCurrency) {
+				reportingExponent := minorUnitsFor(reportingCurrency, ruleset.MinorUnits)
+				var converted int64
+				var rateText, exactValue string
+				var fxErr error
+				if rateDate == "" {
+					fxErr = fmt.Errorf("%s has no valid date for an fx rate; left in %s", key, currency)
+				} else {
+					converted, rateText, exactValue, fxErr = fxTable.convertAmount(amountMinor, currency, exponent, reportingCurrency, reportingExponent, rateDate, rounding)
+				}
+				if fxErr != nil {
+					warnings = append(warnings, fmt.Sprintf("%s: %s", source, fxErr.Error()))
+				} else {
+					if exactValue != "" {
+						fxRounding = &RoundingDecision{
+							Mode:                rounding.Mode,
+							IncrementMinorUnits: rounding.Increment,
+							RawValue:            exactValue,
+						}
+					}
+					original := amountMinor
+					originalAmount = &original
+					originalCurrency = currency
+					fxRateText = rateText
+					amountMinor = converted
+					currency = reportingCurrency
+					exponent = reportingExponent
+				}
+			}
+			reference := ""
+			if ruleset.Grouping != nil && ruleset.Grouping.ReferenceField != "" {
+				reference = strings.TrimSpace(mapped[ruleset.Grouping.ReferenceField])
+			}
+			var fields map[string]string
+			for _, field := range compareFields {
+				if fields == nil {
+					fields = make(map[string]string, len(compareFields))
+				}
+				// Mapped fields are compared under the names mapRecord
+				// stores them as, after normalization.
+				switch field {
+				case ruleset.TimestampField:
+					fields[field] = timestamp
+				case ruleset.AccountField:
+					fields[field] = strings.TrimSpace(account)
+				default:
+					fields[field] = strings.TrimSpace(mapped[field])
+				}
+			}
+
+			err := sorter.add(NormalizedRecord{
+				Source:           source,
+				Key:              key,
+				ID:               recordID,
+				Account:          account,
+				AmountMinor:      amountMinor,
+				Currency:         currency,
+				CurrencyExponent: exponent,
+				Timestamp:        timestamp,
+				Reference:        reference,
+				File:             origin(row),
+				Line:             row.line,
+				Rounding:         roundingDecision,
+				Fields:           fields,
+
+				OriginalCurrency:    originalCurrency,
+				OriginalAmountMinor: originalAmount,
+				FXRate:              fxRateText,
+				FXRounding:          fxRounding,
+				ordinal:             recordsProcessed,
 			})
+			if err != nil {
+				return err
+			}
 			recordsProcessed++
+			return nil
+		}, func(message string) {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", source, message))
+		})
+		if err != nil {
+			rejectedWriter.close()
+			return nil, err
 		}
 	}
+	if err := rejectedWriter.close(); err != nil {
+		return nil, err
+	}
 
-	sort.Slice(normalizedRecords, func(i, j int) bool {
-		if normalizedRecords[i].Key != normalizedRecords[j].Key {
-			return normalizedRecords[i].Key < normalizedRecords[j].Key
-		}
-		if normalizedRecords[i].Source != normalizedRecords[j].Source {
-			return normalizedRecords[i].Source < normalizedRecords[j].Source
-		}
-		if normalizedRecords[i].AmountCents != normalizedRecords[j].AmountCents {
-			return normalizedRecords[i].AmountCents < normalizedRecords[j].AmountCents
+	normalizedPath := filepath.Join(evidenceDir, "normalized.jsonl")
+	normalizedWriter, err := newJSONLinesWriter(normalizedPath)
+	if err != nil {
+		return nil, err
+	}
+	resultsDir, err := os.MkdirTemp("", "settler-engine-results-")
+	if err != nil {
+		return nil, fmt.Errorf("create results dir: %w", err)
+	}
+	defer os.RemoveAll(resultsDir)
+	varianceSpill, err := newResultSpill[VarianceItem](resultsDir, "variances.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	matchSpill, err := newResultSpill[MatchItem](resultsDir, "matches.jsonl")
+	if err != nil {
+		varianceSpill.writer.close()
+		return nil, err
+	}
+	builder := newVarianceBuilder(sources, ruleset, varianceSpill.write, matchSpill.write)
+	err = sorter.each(func(record NormalizedRecord) error {
+		if err := builder.add(record); err != nil {
+			return err
 		}
-		return normalizedRecords[i].ID < normalizedRecords[j].ID
+		return normalizedWriter.write(record)
 	})
-
-	normalizedPath := filepath.Join(evidenceDir, "normalized.jsonl")
-	if err := writeJSONLines(normalizedPath, normalizedRecords); err != nil {
+	if closeErr := normalizedWriter.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		varianceSpill.writer.close()
+		matchSpill.writer.close()
 		return nil, err
 	}
+	if err := sorter.close(); err != nil {
+		varianceSpill.writer.close()
+		matchSpill.writer.close()
+		return nil, fmt.Errorf("remove sort runs: %w", err)
+	}
 
-	varianceItems, varianceSummary := computeVariances(normalizedRecords, sources)
+	varianceItems, matchItems, err := builder.finish()
+	if err != nil {
+		varianceSpill.writer.close()
+		matchSpill.writer.close()
+		return nil, err
+	}
 
 	variancesPath := filepath.Join(evidenceDir, "variances.jsonl")
-	if err := writeJSONLines(variancesPath, varianceItems); err != nil {
+	if err := varianceSpill.mergeInto(variancesPath, varianceItems, varianceItemLess); err != nil {
+		matchSpill.writer.close()
+		return nil, err
+	}
+
+	matchesPath := filepath.Join(evidenceDir, "matches.jsonl")
+	if err := matchSpill.mergeInto(matchesPath, matchItems, matchItemLess); err != nil {
+		return nil, err
+	}
+
+	rulesetHash, err := canonicalRulesetHash(ruleset)
+	if err != nil {
 		return nil, err
 	}
 
@@ -182,12 +388,15 @@ func RunEngine(inputPath string) (*EngineOutput, error) {
 		GeneratedAt:   time.Unix(0, 0).UTC(),
 		ToolVersion:   ToolVersion,
 		SchemaVersion: SchemaVersion,
+		RulesetSHA256: rulesetHash,
 		Files:         []ManifestFile{},
 	}
 
 	manifestFiles := []string{
 		filepath.Join("evidence", "normalized.jsonl"),
 		filepath.Join("evidence", "variances.jsonl"),
+		filepath.Join("evidence", "matches.jsonl"),
+		filepath.Join("evidence", "rejected.jsonl"),
 		filepath.Join("evidence", "logs", "engine.log"),
 	}
 
@@ -212,6 +421,29 @@ func RunEngine(inputPath string) (*EngineOutput, error) {
 		return manifest.Files[i].Path < manifest.Files[j].Path
 	})
 
+	// Input files are hashed as delivered, compressed or not, so the evidence
+	// ties back to the original artifacts rather than to decoded contents.
+	hashedInputs := make(map[string]bool, len(sourceFiles))
+	for _, sourceFile := range sourceFiles {
+		if hashedInputs[sourceFile.path] {
+			continue
+		}
+		hashedInputs[sourceFile.path] = true
+		inputFile, err := manifestInput(baseDir, sourceFile.path)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Inputs = append(manifest.Inputs, inputFile)
+	}
+
+	if input.FXRatesPath != nil && *input.FXRatesPath != "" {
+		inputFile, err := manifestInput(baseDir, *input.FXRatesPath)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Inputs = append(manifest.Inputs, inputFile)
+	}
+
 	manifestPath := filepath.Join(evidenceDir, "manifest.json")
 	if err := writeJSONFile(manifestPath, manifest); err != nil {
 		return nil, err
@@ -224,9 +456,13 @@ func RunEngine(inputPath string) (*EngineOutput, error) {
 			RecordsProcessed: recordsProcessed,
 			RecordsSkipped:   recordsSkipped,
 			Warnings:         warnings,
+			ParseWarnings:    parseWarnings,
 		},
-		VarianceSummary:        varianceSummary,
+		VarianceSummary:        builder.varianceSummary(),
 		VarianceItemsPath:      filepath.Join("evidence", "variances.jsonl"),
+		MatchSummary:           builder.matchSummary(),
+		MatchItemsPath:         filepath.Join("evidence", "matches.jsonl"),
+		RejectedRowsPath:       filepath.Join("evidence", "rejected.jsonl"),
 		EvidenceManifest:       manifest,
 		DeterministicStatement: buildDeterministicStatement(input),
 	}
@@ -243,9 +479,52 @@ func RunEngine(inputPath string) (*EngineOutput, error) {
 	return &output, nil
 }
 
+// manifestInput describes an input artifact for the evidence manifest, keyed by
+// its path relative to the engine input file.
+func manifestInput(baseDir string, path string) (ManifestFile, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return ManifestFile{}, fmt.Errorf("stat %s: %w", path, err)
+	}
+	hash, err := hashFile(path)
+	if err != nil {
+		return ManifestFile{}, fmt.Errorf("hash %s: %w", path, err)
+	}
+	return ManifestFile{Path: relativePath(baseDir, path), SHA256: hash, Bytes: fileInfo.Size()}, nil
+}
+
+// relativePath reports path relative to the engine input file's directory,
+// falling back to path itself.
+func relativePath(baseDir string, path string) string {
+	relPath, err := filepath.Rel(baseDir, path)
+	if err != nil {
+		return path
+	}
+	return relPath
+}
+
 func validateInput(input *EngineInput) error {
-	if len(input.InputFiles) == 0 {
-		return errors.New("input_files must not be empty")
+	if len(input.InputFiles) == 0 && len(input.Sources) == 0 {
+		return errors.New("input_files or sources must not be empty")
+	}
+	if len(input.InputFiles) > 0 && len(input.Sources) > 0 {
+		return errors.New("input_files and sources cannot both be set")
+	}
+	names := make(map[string]bool, len(input.Sources))
+	for _, source := range input.Sources {
+		if source.Name == "" {
+			return errors.New("sources entries need a name")
+		}
+		if names[source.Name] {
+			return fmt.Errorf("duplicate source name: %s", source.Name)
+		}
+		names[source.Name] = true
+		if len(source.Files) == 0 {
+			return fmt.Errorf("source %s must list at least one file", source.Name)
+		}
+		if source.Format != "" && !isInputFormat(source.Format) {
+			return fmt.Errorf("source %s: unsupported format: %s", source.Name, source.Format)
+		}
 	}
 	if input.RulesetPath == "" {
 		return errors.New("ruleset_path is required")
@@ -266,15 +545,33 @@ func validateInput(input *EngineInput) error {
 		input.Mode = "local"
 	}
 
-	if input.RoundingMode != "bankers" && input.RoundingMode != "half_up" {
+	if input.RoundingIncrement == 0 {
+		input.RoundingIncrement = 1
+	}
+	if input.MaxRecordsInMemory == 0 {
+		input.MaxRecordsInMemory = defaultMaxRecordsInMemory
+	}
+
+	if !roundingModes[input.RoundingMode] {
 		return fmt.Errorf("unsupported rounding_mode: %s", input.RoundingMode)
 	}
-	if input.InputFormat != "auto" && input.InputFormat != "csv" && input.InputFormat != "json" {
+	if input.RoundingIncrement < 1 {
+		return errors.New("rounding_increment_minor_units must be at least 1")
+	}
+	if input.MaxRecordsInMemory < 1 {
+		return errors.New("max_records_in_memory must be at least 1")
+	}
+	if !isInputFormat(input.InputFormat) {
 		return fmt.Errorf("unsupported input_format: %s", input.InputFormat)
 	}
 	if input.Mode != "local" && input.Mode != "ci" {
 		return fmt.Errorf("unsupported mode: %s", input.Mode)
 	}
+	hasFXRates := input.FXRatesPath != nil && *input.FXRatesPath != ""
+	hasReportingCurrency := input.ReportingCurrency != nil && *input.ReportingCurrency != ""
+	if hasFXRates != hasReportingCurrency {
+		return errors.New("fx_rates_path and reporting_currency must be set together")
+	}
 
 	if input.Determinism.Rounding == "" {
 		input.Determinism.Rounding = input.RoundingMode
@@ -289,15 +586,65 @@ func validateInput(input *EngineInput) error {
 	return nil
 }
 
+// applyRulesetSettings lets rounding and timezone written into the ruleset
+// fill in the engine input so protocol rulesets run unchanged. Settings the
+// input left out take the ruleset's values; settings the input declares
+// differently fail the run, so the deterministic statement always agrees
+// with both files.
+func applyRulesetSettings(ruleset *Ruleset, input *EngineInput, declared EngineInput) error {
+	conflicts := make([]string, 0)
+	conflict := func(field string, value string, rulesetValue string) {
+		if value != "" && value != rulesetValue {
+			conflicts = append(conflicts, fmt.Sprintf("%s %s (ruleset has %s)", field, value, rulesetValue))
+		}
+	}
+	if ruleset.Rounding != nil {
+		conflict("rounding_mode", declared.RoundingMode, ruleset.Rounding.Mode)
+		conflict("determinism.rounding", declared.Determinism.Rounding, ruleset.Rounding.Mode)
+		if declared.RoundingIncrement != 0 {
+			conflict("rounding_increment_minor_units", strconv.FormatInt(declared.RoundingIncrement, 10), strconv.FormatInt(ruleset.Rounding.IncrementMinorUnits, 10))
+		}
+	}
+	if ruleset.Timezone != "" {
+		conflict("timezone", declared.Timezone, ruleset.Timezone)
+		conflict("determinism.timezone", declared.Determinism.Timezone, ruleset.Timezone)
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("engine input disagrees with the ruleset: %s", strings.Join(conflicts, ", "))
+	}
+
+	if ruleset.Rounding != nil {
+		input.RoundingMode = ruleset.Rounding.Mode
+		input.RoundingIncrement = ruleset.Rounding.IncrementMinorUnits
+		input.Determinism.Rounding = ruleset.Rounding.Mode
+	}
+	if ruleset.Timezone != "" {
+		input.Timezone = ruleset.Timezone
+		input.Determinism.Timezone = ruleset.Timezone
+	}
+	return nil
+}
+
 func loadRuleset(path string) (*Ruleset, error) {
-	bytes, err := os.ReadFile(path)
+	doc, err := readConfigDocument(path)
 	if err != nil {
 		return nil, fmt.Errorf("read ruleset: %w", err)
 	}
 
+	fields, err := doc.keys()
+	if err != nil {
+		return nil, fmt.Errorf("parse ruleset %s: %w", doc.format(), err)
+	}
+
 	var ruleset Ruleset
-	if err := json.Unmarshal(bytes, &ruleset); err != nil {
-		return nil, fmt.Errorf("parse ruleset json: %w", err)
+	if fields["match_keys"] {
+		contract, err := parseContractRuleset(doc, fields)
+		if err != nil {
+			return nil, err
+		}
+		ruleset = contract.toRuleset()
+	} else if err := doc.decode(&ruleset, false); err != nil {
+		return nil, fmt.Errorf("parse ruleset %s: %w", doc.format(), err)
 	}
 
 	if len(ruleset.KeyFields) == 0 {
@@ -315,28 +662,239 @@ func loadRuleset(path string) (*Ruleset, error) {
 	if ruleset.AccountField == "" {
 		ruleset.AccountField = "account"
 	}
+	if ruleset.ToleranceMinorUnits < 0 {
+		return nil, errors.New("ruleset tolerance_minor_units must not be negative")
+	}
+	if ruleset.TolerancePercent < 0 || ruleset.TolerancePercent > 100 {
+		return nil, errors.New("ruleset tolerance_percent must be between 0 and 100")
+	}
+	if ruleset.DateWindowDays != nil && *ruleset.DateWindowDays < 0 {
+		return nil, errors.New("ruleset date_window_days must not be negative")
+	}
+	for currency, exponent := range ruleset.MinorUnits {
+		if exponent < 0 || exponent > 8 {
+			return nil, fmt.Errorf("ruleset minor_units for %s must be between 0 and 8", currency)
+		}
+	}
+	if len(ruleset.MinorUnits) > 0 {
+		overrides := make(map[string]int, len(ruleset.MinorUnits))
+		for currency, exponent := range ruleset.MinorUnits {
+			overrides[strings.ToUpper(currency)] = exponent
+		}
+		ruleset.MinorUnits = overrides
+	}
+	if ruleset.Rounding != nil {
+		if !roundingModes[ruleset.Rounding.Mode] {
+			return nil, fmt.Errorf("unsupported ruleset rounding.mode: %s", ruleset.Rounding.Mode)
+		}
+		if ruleset.Rounding.IncrementMinorUnits == 0 {
+			ruleset.Rounding.IncrementMinorUnits = 1
+		}
+		if ruleset.Rounding.IncrementMinorUnits < 1 {
+			return nil, errors.New("ruleset rounding.increment_minor_units must be at least 1")
+		}
+	}
+	if ruleset.DuplicatePolicy == "" {
+		ruleset.DuplicatePolicy = "sum"
+	}
+	if ruleset.DuplicatePolicy != "sum" && ruleset.DuplicatePolicy != "flag" && ruleset.DuplicatePolicy != "first_wins" {
+		return nil, fmt.Errorf("unsupported ruleset duplicate_policy: %s", ruleset.DuplicatePolicy)
+	}
+	fieldRefs := []*string{&ruleset.AmountField, &ruleset.CurrencyField, &ruleset.TimestampField, &ruleset.AccountField}
+	for index := range ruleset.KeyFields {
+		fieldRefs = append(fieldRefs, &ruleset.KeyFields[index])
+	}
+	for index := range ruleset.CompareKeys {
+		fieldRefs = append(fieldRefs, &ruleset.CompareKeys[index])
+	}
+	if ruleset.Grouping != nil {
+		fieldRefs = append(fieldRefs, &ruleset.Grouping.ReferenceField)
+	}
+	if err := canonicalizeFieldPaths(fieldRefs); err != nil {
+		return nil, fmt.Errorf("ruleset: %w", err)
+	}
+	if ruleset.Grouping != nil {
+		if ruleset.Grouping.WindowDays < 0 {
+			return nil, errors.New("ruleset grouping.window_days must not be negative")
+		}
+		if ruleset.Grouping.ReferenceField == "" && ruleset.Grouping.WindowDays == 0 {
+			return nil, errors.New("ruleset grouping requires reference_field or window_days")
+		}
+	}
 
 	return &ruleset, nil
 }
 
+var contractRulesetFields = []string{"match_keys", "compare_keys", "tolerance_minor_units", "rounding", "timezone", "schema_version"}
+
+var contractRoundingModes = map[string]bool{
+	"down":           true,
+	"up":             true,
+	"nearest":        true,
+	"toward_zero":    true,
+	"away_from_zero": true,
+}
+
+// parseContractRuleset decodes a ruleset written against the published
+// contracts/schemas/ruleset.json. Validation is strict: every required field
+// must be present and unknown fields are rejected, matching the schema's
+// additionalProperties: false.
+func parseContractRuleset(doc *configDocument, fields map[string]bool) (*ContractRuleset, error) {
+	for _, field := range contractRulesetFields {
+		if !fields[field] {
+			return nil, fmt.Errorf("ruleset %s is required", field)
+		}
+	}
+	rounding, err := doc.keys("rounding")
+	if err != nil {
+		return nil, fmt.Errorf("parse ruleset rounding: %w", err)
+	}
+	for _, field := range []string{"mode", "increment_minor_units"} {
+		if !rounding[field] {
+			return nil, fmt.Errorf("ruleset rounding.%s is required", field)
+		}
+	}
+
+	var contract ContractRuleset
+	if err := doc.decode(&contract, true); err != nil {
+		return nil, fmt.Errorf("parse ruleset %s: %w", doc.format(), err)
+	}
+
+	if len(contract.MatchKeys) == 0 {
+		return nil, errors.New("ruleset match_keys must not be empty")
+	}
+	if contract.ToleranceMinorUnits < 0 {
+		return nil, errors.New("ruleset tolerance_minor_units must not be negative")
+	}
+	if !contractRoundingModes[contract.Rounding.Mode] {
+		return nil, fmt.Errorf("unsupported ruleset rounding.mode: %s", contract.Rounding.Mode)
+	}
+	if contract.Rounding.IncrementMinorUnits < 1 {
+		return nil, errors.New("ruleset rounding.increment_minor_units must be at least 1")
+	}
+	if contract.Timezone == "" {
+		return nil, errors.New("ruleset timezone must not be empty")
+	}
+	if contract.SchemaVersion == "" {
+		return nil, errors.New("ruleset schema_version must not be empty")
+	}
+	return &contract, nil
+}
+
+func (contract *ContractRuleset) toRuleset() Ruleset {
+	return Ruleset{
+		SchemaVersion:       contract.SchemaVersion,
+		KeyFields:           append([]string{}, contract.MatchKeys...),
+		CompareKeys:         append([]string{}, contract.CompareKeys...),
+		AmountField:         contract.amountField(),
+		ToleranceMinorUnits: contract.ToleranceMinorUnits,
+		Rounding: &RulesetRounding{
+			Mode:                contract.Rounding.Mode,
+			IncrementMinorUnits: contract.Rounding.IncrementMinorUnits,
+		},
+		Timezone: contract.Timezone,
+	}
+}
+
+// amountField picks the amount among the contract's compare keys, since the
+// contract has no field of its own for it: a key named "amount", else the
+// only key whose last path segment ends in "amount", such as "gross_amount"
+// or "payment.amount". Otherwise it is "amount", and source mappings can
+// point that at any column.
+func (contract *ContractRuleset) amountField() string {
+	candidates := make([]string, 0, 1)
+	for _, field := range contract.CompareKeys {
+		if strings.EqualFold(field, "amount") {
+			return field
+		}
+		segment := strings.ToLower(field[strings.LastIndexByte(field, '.')+1:])
+		if strings.HasSuffix(segment, "amount") {
+			candidates = append(candidates, field)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	return "amount"
+}
+
 func loadMapping(path *string) (*MappingConfig, error) {
 	if path == nil || *path == "" {
 		return &MappingConfig{Sources: map[string]FieldMapping{}}, nil
 	}
-	bytes, err := os.ReadFile(*path)
+	doc, err := readConfigDocument(*path)
 	if err != nil {
 		return nil, fmt.Errorf("read mapping config: %w", err)
 	}
 	var mapping MappingConfig
-	if err := json.Unmarshal(bytes, &mapping); err != nil {
-		return nil, fmt.Errorf("parse mapping config: %w", err)
+	if err := doc.decode(&mapping, false); err != nil {
+		return nil, fmt.Errorf("parse mapping config %s: %w", doc.format(), err)
 	}
 	if mapping.Sources == nil {
 		mapping.Sources = map[string]FieldMapping{}
 	}
+	for source, fieldMapping := range mapping.Sources {
+		if err := validateFieldMapping(&fieldMapping); err != nil {
+			return nil, fmt.Errorf("mapping source %s: %w", source, err)
+		}
+		mapping.Sources[source] = fieldMapping
+	}
 	return &mapping, nil
 }
 
+// validateFieldMapping checks a source's mapping and canonicalizes its field
+// paths and reader settings in place.
+func validateFieldMapping(fieldMapping *FieldMapping) error {
+	if err := validateAmountFormat(fieldMapping.AmountFormat); err != nil {
+		return err
+	}
+	if err := validateCSVDialect(fieldMapping.CSV); err != nil {
+		return err
+	}
+	if err := validateXLSXOptions(fieldMapping.XLSX); err != nil {
+		return err
+	}
+	if err := validateFixedWidthLayout(fieldMapping.FixedWidth); err != nil {
+		return err
+	}
+	if err := validateArchiveMembers(fieldMapping.ArchiveMembers); err != nil {
+		return err
+	}
+	fieldRefs := []*string{&fieldMapping.ID, &fieldMapping.Amount, &fieldMapping.Currency, &fieldMapping.Timestamp, &fieldMapping.Account}
+	if err := canonicalizeFieldPaths(fieldRefs); err != nil {
+		return err
+	}
+	if fieldMapping.RecordsPath != "" {
+		if _, err := parseJSONPath(fieldMapping.RecordsPath); err != nil {
+			return fmt.Errorf("records_path: %w", err)
+		}
+	}
+	return nil
+}
+
+func validateAmountFormat(format *AmountFormat) error {
+	if format == nil {
+		return nil
+	}
+	if format.DecimalSeparator == "" {
+		format.DecimalSeparator = "."
+	}
+	if len([]rune(format.DecimalSeparator)) != 1 {
+		return fmt.Errorf("amount_format decimal_separator must be a single character: %q", format.DecimalSeparator)
+	}
+	if format.GroupingSeparator == format.DecimalSeparator {
+		return errors.New("amount_format grouping_separator must differ from decimal_separator")
+	}
+	for _, style := range format.NegativeStyles {
+		switch style {
+		case "parentheses", "trailing_minus", "cr_negative", "dr_negative":
+		default:
+			return fmt.Errorf("unsupported amount_format negative style: %s", style)
+		}
+	}
+	return nil
+}
+
 func resolveSources(ruleset *Ruleset, inputFiles []string) []string {
 	if len(ruleset.Sources) == len(inputFiles) {
 		return append([]string{}, ruleset.Sources...)
@@ -350,385 +908,2138 @@ func resolveSources(ruleset *Ruleset, inputFiles []string) []string {
 	return sources
 }
 
-func resolvePath(baseDir string, path string) string {
-	if filepath.IsAbs(path) {
-		return path
-	}
-	return filepath.Join(baseDir, path)
-}
-
-func resolvePaths(baseDir string, paths []string) []string {
-	resolved := make([]string, 0, len(paths))
-	for _, path := range paths {
-		resolved = append(resolved, resolvePath(baseDir, path))
+func isInputFormat(format string) bool {
+	switch format {
+	case "auto", "csv", "json", "jsonl", "xlsx", "fixed_width", "mt940", "camt053", "bai2":
+		return true
 	}
-	return resolved
+	return false
 }
 
-func detectFormat(path string) string {
-	ext := strings.ToLower(filepath.Ext(path))
-	if ext == ".csv" {
-		return "csv"
-	}
-	if ext == ".json" {
-		return "json"
-	}
-	return "csv"
+// sourceFile is one input file, the source its records belong to and the
+// format it is read as.
+type sourceFile struct {
+	source string
+	path   string
+	format string
 }
 
-func loadRecords(path string, format string) ([]map[string]string, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, fmt.Errorf("open input file %s: %w", path, err)
-	}
-	defer file.Close()
-
-	switch format {
-	case "csv":
-		return readCSV(file)
-	case "json":
-		return readJSON(file)
-	default:
-		return nil, fmt.Errorf("unsupported format: %s", format)
+// resolveSourceFiles lists the run's sources and the files read for each, in
+// reading order. Files named by input_files map to sources as
+// resolveSources describes; each sources entry reads all of its files, with
+// glob patterns expanded, under its own name. When the ruleset names its
+// sources, the files supplied must cover exactly those sources, and sources
+// are reported in ruleset order.
+func resolveSourceFiles(ruleset *Ruleset, input *EngineInput) ([]string, []sourceFile, error) {
+	if len(input.Sources) == 0 {
+		if len(ruleset.Sources) > 0 && len(ruleset.Sources) != len(input.InputFiles) {
+			return nil, nil, fmt.Errorf("ruleset defines %d sources (%s) but input_files lists %d files; use sources to bind files to source names", len(ruleset.Sources), strings.Join(ruleset.Sources, ", "), len(input.InputFiles))
+		}
+		sources := resolveSources(ruleset, input.InputFiles)
+		files := make([]sourceFile, 0, len(input.InputFiles))
+		for index, path := range input.InputFiles {
+			files = append(files, sourceFile{source: sources[index], path: path, format: input.InputFormat})
+		}
+		return sources, files, nil
 	}
-}
 
-func readCSV(reader io.Reader) ([]map[string]string, error) {
-	csvReader := csv.NewReader(reader)
-	csvReader.TrimLeadingSpace = true
-	rows, err := csvReader.ReadAll()
-	if err != nil {
-		return nil, fmt.Errorf("read csv: %w", err)
+	bound := make(map[string]SourceInput, len(input.Sources))
+	sources := make([]string, 0, len(input.Sources))
+	for _, source := range input.Sources {
+		bound[source.Name] = source
+		sources = append(sources, source.Name)
 	}
-	if len(rows) == 0 {
-		return []map[string]string{}, nil
+	if len(ruleset.Sources) > 0 {
+		defined := make(map[string]bool, len(ruleset.Sources))
+		for _, name := range ruleset.Sources {
+			defined[name] = true
+			if _, ok := bound[name]; !ok {
+				return nil, nil, fmt.Errorf("ruleset source %s has no entry in sources", name)
+			}
+		}
+		for _, name := range sources {
+			if !defined[name] {
+				return nil, nil, fmt.Errorf("source %s is not defined in the ruleset", name)
+			}
+		}
+		sources = append([]string{}, ruleset.Sources...)
 	}
 
-	headers := rows[0]
-	records := make([]map[string]string, 0, len(rows)-1)
-	for _, row := range rows[1:] {
-		record := make(map[string]string, len(headers))
-		for i, header := range headers {
-			if i < len(row) {
-				record[strings.TrimSpace(header)] = strings.TrimSpace(row[i])
-			}
+	files := make([]sourceFile, 0, len(input.Sources))
+	for _, name := range sources {
+		source := bound[name]
+		format := source.Format
+		if format == "" {
+			format = input.InputFormat
+		}
+		paths, err := expandSourceFiles(source.Files)
+		if err != nil {
+			return nil, nil, fmt.Errorf("source %s: %w", name, err)
+		}
+		for _, path := range paths {
+			files = append(files, sourceFile{source: name, path: path, format: format})
 		}
-		records = append(records, record)
 	}
-	return records, nil
+	return sources, files, nil
 }
 
-func readJSON(reader io.Reader) ([]map[string]string, error) {
-	bytes, err := io.ReadAll(reader)
-	if err != nil {
-		return nil, fmt.Errorf("read json: %w", err)
-	}
-	var raw any
-	if err := json.Unmarshal(bytes, &raw); err != nil {
-		return nil, fmt.Errorf("parse json: %w", err)
+// bindSourceMappings adds the mappings given inline in sources to the
+// mapping config. A shared mapping config may cover sources the run does not
+// read; each is reported as a warning. When the run also has sources without
+// a mapping, a mapping was most likely filed under the wrong name, so the run
+// fails rather than reading those sources unmapped.
+func bindSourceMappings(mapping *MappingConfig, inputs []SourceInput, sources []string) ([]string, error) {
+	for _, source := range inputs {
+		if source.Mapping == nil {
+			continue
+		}
+		if _, ok := mapping.Sources[source.Name]; ok {
+			return nil, fmt.Errorf("source %s has a mapping both in sources and in the mapping config", source.Name)
+		}
+		fieldMapping := *source.Mapping
+		if err := validateFieldMapping(&fieldMapping); err != nil {
+			return nil, fmt.Errorf("source %s mapping: %w", source.Name, err)
+		}
+		mapping.Sources[source.Name] = fieldMapping
+	}
+
+	known := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		known[source] = true
+	}
+	unused := make([]string, 0)
+	for name := range mapping.Sources {
+		if !known[name] {
+			unused = append(unused, name)
+		}
+	}
+	if len(unused) == 0 {
+		return nil, nil
+	}
+	sort.Strings(unused)
+	unmapped := make([]string, 0)
+	for _, source := range sources {
+		if _, ok := mapping.Sources[source]; !ok {
+			unmapped = append(unmapped, source)
+		}
+	}
+	if len(unmapped) > 0 {
+		return nil, fmt.Errorf("sources %s have no mapping, but the mapping config has %s", strings.Join(unmapped, ", "), strings.Join(unused, ", "))
+	}
+	warnings := make([]string, 0, len(unused))
+	for _, name := range unused {
+		warnings = append(warnings, fmt.Sprintf("mapping config source %s is not read by this run; ignored", name))
+	}
+	return warnings, nil
+}
+
+// expandSourceFiles expands glob patterns in a source's file list. Matches
+// are read in sorted order, a file listed twice is read once, and a pattern
+// that matches nothing is an error so a missing batch of files does not go
+// unnoticed.
+func expandSourceFiles(patterns []string) ([]string, error) {
+	seen := make(map[string]bool, len(patterns))
+	paths := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		matches := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid file pattern %s: %w", pattern, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("file pattern %s matched no files", pattern)
+			}
+		}
+		for _, path := range matches {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths, nil
+}
+
+func resolvePath(baseDir string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+func resolvePaths(baseDir string, paths []string) []string {
+	resolved := make([]string, 0, len(paths))
+	for _, path := range paths {
+		resolved = append(resolved, resolvePath(baseDir, path))
+	}
+	return resolved
+}
+
+func detectFormat(path string) string {
+	if format, ok := formatForExtension(path); ok {
+		return format
+	}
+	return "csv"
+}
+
+// formatForExtension reports the input format a file name's extension names,
+// looking through a gzip or zstd extension.
+func formatForExtension(path string) (string, bool) {
+	switch strings.ToLower(filepath.Ext(decompressedName(path))) {
+	case ".csv":
+		return "csv", true
+	case ".json":
+		return "json", true
+	case ".jsonl", ".ndjson":
+		return "jsonl", true
+	case ".xlsx":
+		return "xlsx", true
+	case ".sta", ".mt940":
+		return "mt940", true
+	case ".xml":
+		return "camt053", true
+	case ".bai", ".bai2":
+		return "bai2", true
+	}
+	return "", false
+}
+
+// inputRow is one row read from an input file. Line is 1-based where the
+// format has lines, counted within the archive member when the file is a zip
+// archive. A row with a reject reason could not be read as a record and
+// carries only its raw text.
+type inputRow struct {
+	fields map[string]string
+	line   int
+	raw    func() string
+	reject string
+	member string
+}
+
+// streamRecords reads path row by row and calls fn with each record, so input
+// files never need to fit in memory. Gzip and zstd files are decompressed on
+// the fly, and zip archives are read member by member. Rows that are skipped
+// rather than failing the run are reported through warn.
+func streamRecords(path string, format string, settings FieldMapping, fn func(inputRow) error, warn func(string)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open input file %s: %w", path, err)
 	}
+	defer file.Close()
 
-	records := make([]map[string]string, 0)
-	switch typed := raw.(type) {
+	compression, err := inputCompression(file, path, format)
+	if err != nil {
+		return fmt.Errorf("read input file %s: %w", path, err)
+	}
+	switch compression {
+	case "zip":
+		info, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("stat input file %s: %w", path, err)
+		}
+		return readArchive(file, info.Size(), format, settings, fn, warn)
+	case "gzip", "zstd":
+		stream, err := decompress(file, compression)
+		if err != nil {
+			return fmt.Errorf("input file %s: %w", path, err)
+		}
+		defer stream.Close()
+		return readRecords(stream, decompressedName(path), format, settings, fn, warn)
+	default:
+		return readRecords(file, path, format, settings, fn, warn)
+	}
+}
+
+// readRecords reads one uncompressed stream of records. An auto format is
+// detected from name.
+func readRecords(reader io.Reader, name string, format string, settings FieldMapping, fn func(inputRow) error, warn func(string)) error {
+	if format == "auto" {
+		format = detectFormat(name)
+		// Many XML documents are not statements, so the root is checked
+		// before an .xml file is read as camt.053.
+		if format == "camt053" {
+			buffered := bufio.NewReaderSize(reader, 64*1024)
+			if err := sniffCAMT053(buffered); err != nil {
+				return fmt.Errorf("input file %s: %w", name, err)
+			}
+			reader = buffered
+		}
+	}
+	switch format {
+	case "csv":
+		return readCSV(reader, settings.CSV, fn)
+	case "json":
+		return readJSON(reader, settings.RecordsPath, fn, warn)
+	case "jsonl":
+		return readJSONL(reader, fn)
+	case "xlsx":
+		return readXLSXStream(reader, settings.XLSX, fn)
+	case "fixed_width":
+		if settings.FixedWidth == nil {
+			return fmt.Errorf("input file %s: fixed_width format needs a fixed_width layout in the mapping config", name)
+		}
+		return readFixedWidth(reader, settings.FixedWidth, fn)
+	case "mt940":
+		return readMT940(reader, fn)
+	case "camt053":
+		return readCAMT053(reader, fn)
+	case "bai2":
+		return readBAI2(reader, fn)
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+// readCSV streams the rows of a CSV file as records keyed by header, or by
+// 1-based column position when the dialect has no header row. A nil dialect
+// reads comma-separated UTF-8 with the header on the first row.
+func readCSV(reader io.Reader, dialect *CSVDialect, fn func(inputRow) error) error {
+	if dialect == nil {
+		dialect = &CSVDialect{}
+	}
+	input, err := csvInput(reader, dialect)
+	if err != nil {
+		return fmt.Errorf("read csv: %w", err)
+	}
+	recorder := &rawRecorder{reader: input}
+	csvReader := csv.NewReader(recorder)
+	csvReader.TrimLeadingSpace = true
+	csvReader.LazyQuotes = dialect.LazyQuotes
+	if dialect.Delimiter != "" {
+		csvReader.Comma, _ = utf8.DecodeRuneInString(dialect.Delimiter)
+	}
+	if dialect.Comment != "" {
+		csvReader.Comment, _ = utf8.DecodeRuneInString(dialect.Comment)
+	}
+
+	var headers []string
+	if !dialect.NoHeader {
+		// Rows above the header may have any shape; the header then fixes the
+		// column count for the rest of the file.
+		csvReader.FieldsPerRecord = -1
+		for index := 0; index <= dialect.HeaderRow; index++ {
+			row, err := csvReader.Read()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("read csv: %w", err)
+			}
+			headers = row
+		}
+		headers = append([]string(nil), headers...)
+		for index := range headers {
+			headers[index] = strings.TrimSpace(headers[index])
+		}
+	}
+
+	// Field counts are checked here rather than by the csv package, and parse
+	// errors such as a stray quote are turned into rejected rows, so that a
+	// malformed row is rejected on its own instead of ending the read.
+	csvReader.FieldsPerRecord = -1
+	width := len(headers)
+	for {
+		recorder.discard(csvReader.InputOffset())
+		start := csvReader.InputOffset()
+		row, err := csvReader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		end := csvReader.InputOffset()
+		raw := func() string { return recorder.record(start, end, csvReader.Comment) }
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			input := inputRow{line: parseErr.StartLine + dialect.SkipRows, raw: raw, reject: parseErr.Err.Error()}
+			if err := fn(input); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("read csv: %w", err)
+		}
+		line, _ := csvReader.FieldPos(0)
+		input := inputRow{line: line + dialect.SkipRows, raw: raw}
+		if dialect.NoHeader && width == 0 {
+			width = len(row)
+			for len(headers) < width {
+				headers = append(headers, strconv.Itoa(len(headers)+1))
+			}
+		}
+		if len(row) != width {
+			input.reject = fmt.Sprintf("expected %d fields, got %d", width, len(row))
+			if err := fn(input); err != nil {
+				return err
+			}
+			continue
+		}
+		input.fields = make(map[string]string, len(headers))
+		for i, header := range headers {
+			input.fields[header] = strings.TrimSpace(row[i])
+		}
+		if err := fn(input); err != nil {
+			return err
+		}
+	}
+}
+
+// csvRowText returns a function rendering row back into CSV text for
+// rejected-row evidence.
+func csvRowText(row []string, comma rune) func() string {
+	return func() string {
+		var builder strings.Builder
+		writer := csv.NewWriter(&builder)
+		writer.Comma = comma
+		writer.Write(row)
+		writer.Flush()
+		return strings.TrimRight(builder.String(), "\r\n")
+	}
+}
+
+// readJSON streams the objects of the array at recordsPath, decoding one
+// element at a time. Without a path the document may be a top-level array or
+// an object holding a "records" array.
+func readJSON(reader io.Reader, recordsPath string, fn func(inputRow) error, warn func(string)) error {
+	decoder := json.NewDecoder(bufio.NewReader(reader))
+	decoder.UseNumber()
+	first, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("parse json: %w", err)
+	}
+
+	var segments []jsonPathSegment
+	switch {
+	case recordsPath != "":
+		segments, err = parseJSONPath(recordsPath)
+		if err != nil {
+			return fmt.Errorf("records_path: %w", err)
+		}
+	case first == json.Delim('['):
+	case first == json.Delim('{'):
+		segments = []jsonPathSegment{{name: "records", index: -1}}
+	default:
+		return errors.New("unsupported json structure")
+	}
+
+	target, found, err := seekJSONPath(decoder, first, segments)
+	if err != nil {
+		return err
+	}
+	switch {
+	case found && target == json.Delim('['):
+		if err := readJSONArray(decoder, fn, warn); err != nil {
+			return err
+		}
+		if err := closeJSONContainers(decoder, len(segments)); err != nil {
+			return err
+		}
+	case found:
+		if recordsPath != "" {
+			return fmt.Errorf("records_path %s is not an array", recordsPath)
+		}
+		if err := skipJSONValue(decoder, target); err != nil {
+			return err
+		}
+		if err := closeJSONContainers(decoder, len(segments)); err != nil {
+			return err
+		}
+		warn("records is not an array; no records read")
+	default:
+		if recordsPath != "" {
+			return fmt.Errorf("records_path %s not found", recordsPath)
+		}
+		warn("json object has no records array; no records read")
+	}
+
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return errors.New("parse json: unexpected data after top-level value")
+	}
+	return nil
+}
+
+// jsonPathSegment is one step of a records path: an object member by name, or
+// an array element when index is not negative.
+type jsonPathSegment struct {
+	name  string
+	index int
+}
+
+// parseJSONPath splits a records path such as "results.transactions" or
+// "$.pages[0].items" into segments.
+func parseJSONPath(path string) ([]jsonPathSegment, error) {
+	canonical, err := normalizeJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]jsonPathSegment, 0)
+	for _, part := range strings.Split(canonical, ".") {
+		name, indexes := part, ""
+		if bracket := strings.IndexByte(part, '['); bracket >= 0 {
+			name, indexes = part[:bracket], part[bracket:]
+		}
+		if name == "" && indexes == "" {
+			return nil, fmt.Errorf("empty segment in path %q", path)
+		}
+		if name != "" {
+			segments = append(segments, jsonPathSegment{name: name, index: -1})
+		}
+		for indexes != "" {
+			end := strings.IndexByte(indexes, ']')
+			if indexes[0] != '[' || end < 0 {
+				return nil, fmt.Errorf("unexpected %q in path %q", indexes, path)
+			}
+			index, err := strconv.Atoi(indexes[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid index in path %q: %w", path, err)
+			}
+			segments = append(segments, jsonPathSegment{index: index})
+			indexes = indexes[end+1:]
+		}
+	}
+	return segments, nil
+}
+
+// seekJSONPath follows segments from a value whose opening token first has
+// been read. When the path exists it stops at the target value and returns
+// its opening token, leaving one container per segment open; otherwise the
+// whole value is consumed and found is false.
+func seekJSONPath(decoder *json.Decoder, first json.Token, segments []jsonPathSegment) (json.Token, bool, error) {
+	if len(segments) == 0 {
+		return first, true, nil
+	}
+	segment := segments[0]
+	want := json.Delim('{')
+	if segment.index >= 0 {
+		want = json.Delim('[')
+	}
+	if first != want {
+		return nil, false, skipJSONValue(decoder, first)
+	}
+
+	for position := 0; decoder.More(); position++ {
+		matched := position == segment.index
+		if segment.index < 0 {
+			name, err := decoder.Token()
+			if err != nil {
+				return nil, false, fmt.Errorf("parse json: %w", err)
+			}
+			matched = name == segment.name
+		}
+		value, err := decoder.Token()
+		if err != nil {
+			return nil, false, fmt.Errorf("parse json: %w", err)
+		}
+		if !matched {
+			if err := skipJSONValue(decoder, value); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		target, found, err := seekJSONPath(decoder, value, segments[1:])
+		if err != nil || found {
+			return target, found, err
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, false, fmt.Errorf("parse json: %w", err)
+	}
+	return nil, false, nil
+}
+
+// readJSONL reads one JSON object per line. Blank lines are ignored, and lines
+// that are not a JSON object are passed on as rejected rows.
+func readJSONL(reader io.Reader, fn func(inputRow) error) error {
+	buffered := bufio.NewReader(reader)
+	for line := 1; ; line++ {
+		text, err := buffered.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("read jsonl: %w", err)
+		}
+		if trimmed := bytes.TrimSpace(text); len(trimmed) > 0 {
+			raw := string(trimmed)
+			input := inputRow{line: line, raw: func() string { return raw }}
+			var value any
+			if parseErr := decodeJSONValue(trimmed, &value); parseErr != nil {
+				input.reject = fmt.Sprintf("malformed json: %v", parseErr)
+			} else if object, ok := value.(map[string]any); !ok {
+				input.reject = "expected a json object"
+			} else {
+				input.fields = stringifyMap(object)
+			}
+			if err := fn(input); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+}
+
+// decodeJSONValue unmarshals a single JSON document, keeping numbers as
+// json.Number so amounts retain their exact decimal text.
+func decodeJSONValue(data []byte, target any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(target); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return errors.New("unexpected data after json value")
+	}
+	return nil
+}
+
+// readJSONArray decodes array elements after the opening bracket has been
+// consumed, passing objects to fn. Other values are skipped and counted.
+func readJSONArray(decoder *json.Decoder, fn func(inputRow) error, warn func(string)) error {
+	skipped := 0
+	for decoder.More() {
+		var item any
+		if err := decoder.Decode(&item); err != nil {
+			return fmt.Errorf("parse json: %w", err)
+		}
+		object, ok := item.(map[string]any)
+		if !ok {
+			skipped++
+			continue
+		}
+		input := inputRow{fields: stringifyMap(object), raw: jsonText(object)}
+		if err := fn(input); err != nil {
+			return err
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("parse json: %w", err)
+	}
+	if skipped > 0 {
+		warn(fmt.Sprintf("skipped %d non-object items", skipped))
+	}
+	return nil
+}
+
+// jsonText returns a function rendering value as compact JSON for
+// rejected-row evidence.
+func jsonText(value any) func() string {
+	return func() string {
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	}
+}
+
+// skipJSONValue discards the rest of a value whose first token has already
+// been read.
+func skipJSONValue(decoder *json.Decoder, first json.Token) error {
+	if first == json.Delim('[') || first == json.Delim('{') {
+		return closeJSONContainers(decoder, 1)
+	}
+	return nil
+}
+
+// closeJSONContainers reads tokens until depth open arrays or objects have
+// been closed.
+func closeJSONContainers(decoder *json.Decoder, depth int) error {
+	for depth > 0 {
+		token, err := decoder.Token()
+		if err != nil {
+			return fmt.Errorf("parse json: %w", err)
+		}
+		switch token {
+		case json.Delim('['), json.Delim('{'):
+			depth++
+		case json.Delim(']'), json.Delim('}'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// stringifyMap renders a decoded JSON object as flat string fields. Top-level
+// values keep their own key, with nested objects and arrays rendered as JSON;
+// every scalar beneath them is also exposed under its path, such as
+// payment.amount.value or lines[0].sku.
+func stringifyMap(data map[string]any) map[string]string {
+	result := make(map[string]string, len(data))
+	for key, value := range data {
+		result[key] = stringifyValue(value)
+	}
+	for _, key := range sortedKeys(data) {
+		switch data[key].(type) {
+		case map[string]any, []any:
+			flattenValue(result, key, data[key])
+		}
+	}
+	return result
+}
+
+// flattenValue adds the scalars beneath value to result keyed by their path.
+// Keys are visited in sorted order and an existing key is never overwritten,
+// so a literal top-level "a.b" wins over a nested path that spells the same.
+func flattenValue(result map[string]string, path string, value any) {
+	switch typed := value.(type) {
+	case map[string]any:
+		for _, key := range sortedKeys(typed) {
+			flattenValue(result, path+"."+key, typed[key])
+		}
 	case []any:
-		for _, item := range typed {
-			if object, ok := item.(map[string]any); ok {
-				records = append(records, stringifyMap(object))
+		for index, item := range typed {
+			flattenValue(result, fmt.Sprintf("%s[%d]", path, index), item)
+		}
+	default:
+		if _, exists := result[path]; !exists {
+			result[path] = stringifyValue(value)
+		}
+	}
+}
+
+func stringifyValue(value any) string {
+	switch typed := value.(type) {
+	case string:
+		return typed
+	case json.Number:
+		return plainDecimal(typed.String())
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(typed)
+	case nil:
+		return ""
+	default:
+		encoded, _ := json.Marshal(typed)
+		return string(encoded)
+	}
+}
+
+// plainDecimal rewrites a number in exponent notation, such as 1.5e-1, as
+// the equivalent plain decimal without passing through a float.
+func plainDecimal(text string) string {
+	cut := strings.IndexAny(text, "eE")
+	if cut < 0 {
+		return text
+	}
+	exponent, err := strconv.Atoi(text[cut+1:])
+	if err != nil || exponent > 1000 || exponent < -1000 {
+		return text
+	}
+	mantissa, sign := text[:cut], ""
+	if strings.HasPrefix(mantissa, "-") {
+		mantissa, sign = mantissa[1:], "-"
+	}
+	integer, fraction, _ := strings.Cut(mantissa, ".")
+	digits := integer + fraction
+	point := len(integer) + exponent
+
+	var result string
+	switch {
+	case point <= 0:
+		result = "0." + strings.Repeat("0", -point) + digits
+	case point >= len(digits):
+		result = digits + strings.Repeat("0", point-len(digits))
+	default:
+		result = digits[:point] + "." + digits[point:]
+	}
+	if trimmed := strings.TrimLeft(result, "0"); trimmed == "" || trimmed[0] == '.' {
+		result = "0" + trimmed
+	} else {
+		result = trimmed
+	}
+	return sign + result
+}
+
+func sortedKeys(data map[string]any) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// canonicalFieldPath rewrites a field reference that starts with "$" into
+// the path form produced by stringifyMap, so "$.payment.amount.value" and
+// "$['payment']['amount']['value']" both become "payment.amount.value".
+// Anything else is a literal field name, which already matches a flattened
+// JSON path such as "lines[0].sku" and keeps tabular headers like
+// "Amount [USD]" intact.
+func canonicalFieldPath(ref string) (string, error) {
+	if ref != "$" && !strings.HasPrefix(ref, "$.") && !strings.HasPrefix(ref, "$[") {
+		return ref, nil
+	}
+	return normalizeJSONPath(ref)
+}
+
+// normalizeJSONPath drops a leading "$" and rewrites bracketed names as
+// dotted segments; array indexes stay as "[0]".
+func normalizeJSONPath(ref string) (string, error) {
+	path := ref
+	if strings.HasPrefix(path, "$") {
+		path = strings.TrimPrefix(path[1:], ".")
+	}
+
+	var builder strings.Builder
+	for index := 0; index < len(path); {
+		if path[index] != '[' {
+			builder.WriteByte(path[index])
+			index++
+			continue
+		}
+		end := strings.IndexByte(path[index:], ']')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated [ in field path %q", ref)
+		}
+		inner := path[index+1 : index+end]
+		index += end + 1
+		if isDigits(inner) {
+			builder.WriteString("[" + inner + "]")
+			continue
+		}
+		if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+			if builder.Len() > 0 {
+				builder.WriteByte('.')
 			}
+			builder.WriteString(inner[1 : len(inner)-1])
+			continue
+		}
+		return "", fmt.Errorf("unsupported index %q in field path %q", inner, ref)
+	}
+	if builder.Len() == 0 {
+		return "", fmt.Errorf("field path %q selects no field", ref)
+	}
+	return builder.String(), nil
+}
+
+// canonicalizeFieldPaths applies canonicalFieldPath to each non-empty
+// reference in place.
+func canonicalizeFieldPaths(refs []*string) error {
+	for _, ref := range refs {
+		if *ref == "" {
+			continue
+		}
+		path, err := canonicalFieldPath(*ref)
+		if err != nil {
+			return err
+		}
+		*ref = path
+	}
+	return nil
+}
+
+func mapRecord(record map[string]string, source string, ruleset *Ruleset, mapping *MappingConfig) map[string]string {
+	fieldMapping, ok := mapping.Sources[source]
+	if !ok {
+		fieldMapping = FieldMapping{
+			ID:        "id",
+			Amount:    ruleset.AmountField,
+			Currency:  ruleset.CurrencyField,
+			Timestamp: ruleset.TimestampField,
+			Account:   ruleset.AccountField,
+		}
+	}
+
+	mapped := make(map[string]string, len(record)+2)
+	for key, value := range record {
+		mapped[key] = value
+	}
+
+	if fieldMapping.ID != "" {
+		mapped["id"] = record[fieldMapping.ID]
+	}
+	if fieldMapping.Amount != "" {
+		mapped[ruleset.AmountField] = record[fieldMapping.Amount]
+	}
+	if fieldMapping.Currency != "" {
+		mapped[ruleset.CurrencyField] = record[fieldMapping.Currency]
+	}
+	if fieldMapping.Timestamp != "" {
+		mapped[ruleset.TimestampField] = record[fieldMapping.Timestamp]
+	}
+	if fieldMapping.Account != "" {
+		mapped["account"] = record[fieldMapping.Account]
+	}
+
+	return mapped
+}
+
+func buildKey(record map[string]string, fields []string) (string, []string) {
+	parts := make([]string, 0, len(fields))
+	warnings := make([]string, 0)
+	for _, field := range fields {
+		value := strings.TrimSpace(record[field])
+		if value == "" {
+			warnings = append(warnings, fmt.Sprintf("missing key field %s", field))
+			return "", warnings
+		}
+		parts = append(parts, fmt.Sprintf("%s=%s", field, value))
+	}
+	return strings.Join(parts, "|"), warnings
+}
+
+// parseAmount converts a decimal string into minor units of a currency with
+// the given exponent, rounding any excess precision with the rule. The boolean
+// result reports whether rounding changed the value.
+func parseAmount(value string, rounding roundingRule, exponent int) (int64, bool, string) {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		return 0, false, "missing amount"
+	}
+
+	negative := false
+	if strings.HasPrefix(trimmed, "-") {
+		negative = true
+		trimmed = strings.TrimPrefix(trimmed, "-")
+	} else {
+		trimmed = strings.TrimPrefix(trimmed, "+")
+	}
+
+	parts := strings.SplitN(trimmed, ".", 2)
+	wholePart := parts[0]
+	fractionPart := ""
+	if len(parts) == 2 {
+		fractionPart = parts[1]
+	}
+	if wholePart == "" {
+		wholePart = "0"
+	}
+	if !isDigits(wholePart) || (fractionPart != "" && !isDigits(fractionPart)) {
+		return 0, false, fmt.Sprintf("invalid amount: %s", value)
+	}
+
+	digits, _ := new(big.Int).SetString(wholePart+fractionPart, 10)
+	numerator := new(big.Int).Mul(digits, pow10(exponent))
+	amount := new(big.Rat).SetFrac(numerator, pow10(len(fractionPart)))
+	if negative {
+		amount.Neg(amount)
+	}
+
+	result, ok := roundRat(amount, rounding)
+	if !ok {
+		return 0, false, fmt.Sprintf("amount out of range: %s", value)
+	}
+	return result, amount.Cmp(new(big.Rat).SetInt64(result)) != 0, ""
+}
+
+// normalizeAmountText rewrites a locale-formatted amount such as "1.234,56",
+// "(45.00)", "$12.00" or "12.00 CR" into the plain "-1234.56" form accepted by
+// parseAmount. Without a format the value is passed through unchanged.
+func normalizeAmountText(value string, format *AmountFormat) (string, string) {
+	text := strings.TrimSpace(value)
+	if format == nil || text == "" {
+		return text, ""
+	}
+
+	negative := false
+	styles := map[string]bool{}
+	for _, style := range format.NegativeStyles {
+		styles[style] = true
+	}
+
+	upper := strings.ToUpper(text)
+	if styles["cr_negative"] || styles["dr_negative"] {
+		for _, marker := range []string{"CR", "DR"} {
+			if strings.HasSuffix(upper, marker) {
+				text = strings.TrimSpace(text[:len(text)-len(marker)])
+				negative = (marker == "CR" && styles["cr_negative"]) || (marker == "DR" && styles["dr_negative"])
+				break
+			}
+		}
+	}
+
+	if format.StripSymbols {
+		text = strings.TrimSpace(strings.Map(func(r rune) rune {
+			if unicode.Is(unicode.Sc, r) {
+				return -1
+			}
+			return r
+		}, text))
+		text = stripCurrencyCode(text)
+	}
+
+	if styles["parentheses"] && strings.HasPrefix(text, "(") && strings.HasSuffix(text, ")") {
+		text = strings.TrimSpace(text[1 : len(text)-1])
+		negative = !negative
+	}
+	if styles["trailing_minus"] && strings.HasSuffix(text, "-") {
+		text = strings.TrimSpace(strings.TrimSuffix(text, "-"))
+		negative = !negative
+	}
+
+	if format.GroupingSeparator != "" {
+		separators := []string{format.GroupingSeparator}
+		if strings.TrimSpace(format.GroupingSeparator) == "" {
+			separators = append(separators, "\u00a0", "\u202f")
+		}
+		integerPart := text
+		fractionPart := ""
+		if index := strings.LastIndex(text, format.DecimalSeparator); index >= 0 {
+			integerPart = text[:index]
+			fractionPart = text[index:]
+		}
+		for _, separator := range separators {
+			if strings.Contains(fractionPart, separator) {
+				return "", fmt.Sprintf("invalid amount: %s", value)
+			}
+			integerPart = strings.ReplaceAll(integerPart, separator, "")
+		}
+		text = integerPart + fractionPart
+	}
+	if format.DecimalSeparator != "." {
+		if strings.Contains(text, ".") {
+			return "", fmt.Sprintf("invalid amount: %s", value)
+		}
+		text = strings.Replace(text, format.DecimalSeparator, ".", 1)
+	}
+
+	if negative {
+		if strings.HasPrefix(text, "-") {
+			return "", fmt.Sprintf("invalid amount: %s", value)
+		}
+		text = "-" + text
+	}
+	return text, ""
+}
+
+// stripCurrencyCode removes a three-letter currency code written before or
+// after the number, as in "USD 12.00" or "12.00 EUR".
+func stripCurrencyCode(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) != 2 {
+		return text
+	}
+	if isCurrencyCode(fields[0]) {
+		return fields[1]
+	}
+	if isCurrencyCode(fields[1]) {
+		return fields[0]
+	}
+	return text
+}
+
+func isCurrencyCode(value string) bool {
+	if len(value) != 3 {
+		return false
+	}
+	for _, char := range value {
+		if char < 'A' || char > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// roundingRule selects how excess precision is removed from an amount and the
+// increment, in minor units, that results are rounded to.
+type roundingRule struct {
+	Mode      string
+	Increment int64
+}
+
+var roundingModes = map[string]bool{
+	"bankers":        true,
+	"half_up":        true,
+	"nearest":        true,
+	"down":           true,
+	"up":             true,
+	"toward_zero":    true,
+	"away_from_zero": true,
+}
+
+// roundRat rounds a rational amount to a multiple of the rule's increment.
+// "down" and "up" round toward negative and positive infinity, while
+// "nearest" and "half_up" break ties away from zero and "bankers" breaks them
+// toward the even multiple. The second result is false when the rounded value
+// does not fit in an int64.
+func roundRat(value *big.Rat, rule roundingRule) (int64, bool) {
+	increment := rule.Increment
+	if increment < 1 {
+		increment = 1
+	}
+	scaled := new(big.Rat).Quo(value, new(big.Rat).SetInt64(increment))
+	negative := scaled.Sign() < 0
+	magnitude := new(big.Rat).Abs(scaled)
+	quotient, remainder := new(big.Int).QuoRem(magnitude.Num(), magnitude.Denom(), new(big.Int))
+
+	exact := remainder.Sign() == 0
+	twiceRemainder := new(big.Int).Mul(remainder, big.NewInt(2))
+	comparison := twiceRemainder.Cmp(magnitude.Denom())
+	roundUp := false
+	switch rule.Mode {
+	case "down":
+		roundUp = negative && !exact
+	case "up":
+		roundUp = !negative && !exact
+	case "toward_zero":
+		roundUp = false
+	case "away_from_zero":
+		roundUp = !exact
+	case "half_up", "nearest":
+		roundUp = comparison >= 0
+	default:
+		roundUp = comparison > 0 || (comparison == 0 && quotient.Bit(0) == 1)
+	}
+	if roundUp {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+
+	quotient.Mul(quotient, big.NewInt(increment))
+	if !quotient.IsInt64() {
+		return 0, false
+	}
+	result := quotient.Int64()
+	if negative {
+		result = -result
+	}
+	return result, true
+}
+
+func normalizeTimestamp(value string, location *time.Location) (string, string) {
+	layouts := []string{
+		time.RFC3339,
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+	}
+	for _, layout := range layouts {
+		if parsed, err := time.ParseInLocation(layout, value, location); err == nil {
+			return parsed.In(location).Format(time.RFC3339), ""
+		}
+	}
+	return value, fmt.Sprintf("unparsed timestamp: %s", value)
+}
+
+// keyGroup collects the per-source totals for one match key along with the
+// attributes used by the fuzzy matching pass.
+type keyGroup struct {
+	key       string
+	currency  string
+	account   string
+	timestamp string
+	reference string
+	amounts   map[string]int64
+	recordIDs map[string][]string
+
+	currencyAmounts map[string]map[string]int64
+	originalAmounts map[string]map[string]int64
+	// fields holds, per source and compared field, the distinct values seen
+	// across every kept record, in record order.
+	fields map[string]map[string][]string
+}
+
+// mixedCurrency reports whether the group's records disagree on currency.
+func (group *keyGroup) mixedCurrency() bool {
+	seen := ""
+	for _, byCurrency := range group.currencyAmounts {
+		for currency := range byCurrency {
+			if seen == "" {
+				seen = currency
+			} else if currency != seen {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// varianceBuilder folds a record stream sorted by key and source into
+// variance and match items. Duplicates are resolved as each run of records
+// sharing a key and source completes, and each key group is settled as soon
+// as the next key starts: its results go straight to the item and match
+// sinks, in key and type order. Only groups still missing a source are kept,
+// for the fuzzy and aggregate passes in finish, so memory does not grow with
+// the number of records that reconcile.
+type varianceBuilder struct {
+	sources       []string
+	ruleset       *Ruleset
+	compareFields []string
+	emitItem      func(VarianceItem) error
+	emitMatch     func(MatchItem) error
+
+	run       []NormalizedRecord
+	current   *keyGroup
+	duplicate *VarianceItem
+	leftovers []*keyGroup
+
+	counts      map[string]int
+	total       int
+	byCurrency  map[string]CurrencyVarianceTotals
+	matchCounts map[string]int
+	matchTotal  int
+}
+
+func newVarianceBuilder(sources []string, ruleset *Ruleset, emitItem func(VarianceItem) error, emitMatch func(MatchItem) error) *varianceBuilder {
+	matchCounts := map[string]int{}
+	for _, matchType := range matchTypes {
+		matchCounts[matchType] = 0
+	}
+	return &varianceBuilder{
+		sources:       sources,
+		ruleset:       ruleset,
+		compareFields: comparedFields(ruleset),
+		emitItem:      emitItem,
+		emitMatch:     emitMatch,
+		counts: map[string]int{
+			"missing_record":    0,
+			"amount_mismatch":   0,
+			"duplicate_record":  0,
+			"currency_mismatch": 0,
+			"field_mismatch":    0,
+		},
+		byCurrency:  map[string]CurrencyVarianceTotals{},
+		matchCounts: matchCounts,
+	}
+}
+
+func (builder *varianceBuilder) add(record NormalizedRecord) error {
+	if len(builder.run) > 0 && (builder.run[0].Key != record.Key || builder.run[0].Source != record.Source) {
+		builder.flushRun()
+	}
+	if builder.current != nil && builder.current.key != record.Key {
+		if err := builder.settle(); err != nil {
+			return err
+		}
+	}
+	builder.run = append(builder.run, record)
+	return nil
+}
+
+func (builder *varianceBuilder) flushRun() {
+	if len(builder.run) == 0 {
+		return
+	}
+	kept, item := applyDuplicatePolicy(builder.run, builder.ruleset.DuplicatePolicy)
+	if item != nil {
+		// Several sources may collide on the same key; report them as one item.
+		if builder.duplicate != nil {
+			builder.duplicate.DuplicateRecords = append(builder.duplicate.DuplicateRecords, item.DuplicateRecords...)
+		} else {
+			builder.duplicate = item
+		}
+	}
+	for _, record := range kept {
+		builder.group(record)
+	}
+	builder.run = builder.run[:0]
+}
+
+func (builder *varianceBuilder) group(record NormalizedRecord) {
+	group := builder.current
+	if group == nil {
+		group = &keyGroup{
+			key:             record.Key,
+			amounts:         map[string]int64{},
+			recordIDs:       map[string][]string{},
+			currencyAmounts: map[string]map[string]int64{},
+			originalAmounts: map[string]map[string]int64{},
+			fields:          map[string]map[string][]string{},
+		}
+		builder.current = group
+	}
+	group.amounts[record.Source] += record.AmountMinor
+	if group.currencyAmounts[record.Source] == nil {
+		group.currencyAmounts[record.Source] = map[string]int64{}
+	}
+	group.currencyAmounts[record.Source][record.Currency] += record.AmountMinor
+	if record.OriginalAmountMinor != nil {
+		if group.originalAmounts[record.Source] == nil {
+			group.originalAmounts[record.Source] = map[string]int64{}
+		}
+		group.originalAmounts[record.Source][record.OriginalCurrency] += *record.OriginalAmountMinor
+	}
+	group.recordIDs[record.Source] = append(group.recordIDs[record.Source], record.ID)
+	if group.reference == "" {
+		group.reference = record.Reference
+	}
+	if group.currency == "" {
+		group.currency = record.Currency
+	}
+	if group.account == "" {
+		group.account = record.Account
+	}
+	if group.timestamp == "" {
+		group.timestamp = record.Timestamp
+	}
+	if len(builder.compareFields) > 0 {
+		if group.fields[record.Source] == nil {
+			group.fields[record.Source] = map[string][]string{}
+		}
+		values := group.fields[record.Source]
+		for _, field := range builder.compareFields {
+			value := record.Fields[field]
+			if !slices.Contains(values[field], value) {
+				values[field] = append(values[field], value)
+			}
+		}
+	}
+}
+
+// settle classifies the current key group once all of its records are in. A
+// group missing a source is kept for finish, since a fuzzy or aggregate match
+// may still claim it; any other group's results are emitted now.
+func (builder *varianceBuilder) settle() error {
+	group, sources := builder.current, builder.sources
+	items := make([]VarianceItem, 0, 2)
+	if builder.duplicate != nil {
+		items = append(items, *builder.duplicate)
+	}
+	builder.current, builder.duplicate = nil, nil
+	if group == nil {
+		return builder.emitItems(items)
+	}
+
+	amounts := withOriginalAmounts(amountsBySource(group.amounts, sources), group.originalAmounts)
+	missingSources := missingSourcesFor(group, sources)
+	switch {
+	case group.mixedCurrency():
+		items = append(items, VarianceItem{
+			Key:             group.key,
+			Type:            "currency_mismatch",
+			AmountsBySource: currencyAmountsBySource(group.currencyAmounts, sources),
+			MissingSources:  missingSources,
+		})
+	case len(missingSources) > 0:
+		// Compared fields are kept, since a fuzzy or aggregate match may
+		// still pair the group with records that disagree on them.
+		builder.leftovers = append(builder.leftovers, group)
+	default:
+		if differences := fieldDifferences(group, builder.compareFields, sources); len(differences) > 0 {
+			items = append(items, VarianceItem{
+				Key:              group.key,
+				Type:             "field_mismatch",
+				Currency:         group.currency,
+				FieldDifferences: differences,
+			})
+		}
+
+		minAmount, maxAmount := amounts[0].AmountMinor, amounts[0].AmountMinor
+		for _, amount := range amounts[1:] {
+			if amount.AmountMinor < minAmount {
+				minAmount = amount.AmountMinor
+			}
+			if amount.AmountMinor > maxAmount {
+				maxAmount = amount.AmountMinor
+			}
+		}
+		if minAmount == maxAmount {
+			if err := builder.emitMatches([]MatchItem{exactMatch(group, "exact", sources, amounts)}); err != nil {
+				return err
+			}
+			break
+		}
+
+		// A difference within tolerance is reconciled: it is recorded as a
+		// match carrying the tolerance applied, not as a variance.
+		tolerance := toleranceFor(builder.ruleset, minAmount, maxAmount)
+		if maxAmount-minAmount <= tolerance {
+			match := exactMatch(group, "within_tolerance", sources, amounts)
+			match.Confidence = toleranceConfidence(maxAmount-minAmount, tolerance)
+			match.ToleranceMinorUnits = &tolerance
+			if err := builder.emitMatches([]MatchItem{match}); err != nil {
+				return err
+			}
+			break
+		}
+		items = append(items, VarianceItem{
+			Key:                 group.key,
+			Type:                "amount_mismatch",
+			Currency:            group.currency,
+			AmountsBySource:     amounts,
+			ToleranceMinorUnits: &tolerance,
+		})
+	}
+	return builder.emitItems(items)
+}
+
+func (builder *varianceBuilder) emitItems(items []VarianceItem) error {
+	sortVarianceItems(items)
+	for _, item := range items {
+		builder.countItem(item)
+		if err := builder.emitItem(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (builder *varianceBuilder) emitMatches(matches []MatchItem) error {
+	sortMatchItems(matches)
+	for _, match := range matches {
+		builder.countMatch(match)
+		if err := builder.emitMatch(match); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (builder *varianceBuilder) countItem(item VarianceItem) {
+	builder.counts[item.Type]++
+	builder.total++
+	addCurrencyExposure(builder.byCurrency, item, builder.sources)
+}
+
+func (builder *varianceBuilder) countMatch(match MatchItem) {
+	builder.matchCounts[match.Type]++
+	builder.matchTotal++
+}
+
+// finish settles the last key group and runs the fuzzy and aggregate passes
+// over the groups still missing a source. Their results are returned sorted
+// by key and type rather than emitted, for the caller to merge with the
+// results already emitted.
+func (builder *varianceBuilder) finish() ([]VarianceItem, []MatchItem, error) {
+	builder.flushRun()
+	if builder.current != nil || builder.duplicate != nil {
+		if err := builder.settle(); err != nil {
+			return nil, nil, err
+		}
+	}
+	leftovers, sources, ruleset := builder.leftovers, builder.sources, builder.ruleset
+	builder.leftovers = nil
+
+	items := make([]VarianceItem, 0)
+	matches, paired := pairFuzzyMatches(leftovers, sources, ruleset.DateWindowDays)
+	// A fuzzy pair is a match; it is a variance only where its amounts
+	// disagree.
+	for _, match := range matches {
+		if match.Type == "fuzzy_match" && !uniformSourceAmounts(match.AmountsBySource) {
+			items = append(items, VarianceItem{
+				Key:             match.Key,
+				Type:            "amount_mismatch",
+				Currency:        match.Currency,
+				AmountsBySource: match.AmountsBySource,
+				MatchedKeys:     match.MatchedKeys,
+				DateDeltaDays:   match.DateDeltaDays,
+			})
+		}
+	}
+	if ruleset.Grouping != nil {
+		remaining := make([]*keyGroup, 0, len(leftovers))
+		for _, group := range leftovers {
+			if !paired[group.key] {
+				remaining = append(remaining, group)
+			}
+		}
+		matches = append(matches, matchAggregates(remaining, sources, ruleset.Grouping, paired)...)
+	}
+	byKey := make(map[string]*keyGroup, len(leftovers))
+	for _, group := range leftovers {
+		byKey[group.key] = group
+	}
+	for _, match := range matches {
+		groups := make([]*keyGroup, 0, len(match.MatchedKeys))
+		for _, key := range match.MatchedKeys {
+			groups = append(groups, byKey[key])
 		}
-	case map[string]any:
-		if items, ok := typed["records"].([]any); ok {
-			for _, item := range items {
-				if object, ok := item.(map[string]any); ok {
-					records = append(records, stringifyMap(object))
-				}
-			}
+		if differences := mergedFieldDifferences(groups, builder.compareFields, sources); len(differences) > 0 {
+			items = append(items, VarianceItem{
+				Key:              match.Key,
+				Type:             "field_mismatch",
+				Currency:         match.Currency,
+				MatchedKeys:      match.MatchedKeys,
+				FieldDifferences: differences,
+			})
 		}
-	default:
-		return nil, errors.New("unsupported json structure")
 	}
-	return records, nil
+	for _, group := range leftovers {
+		if paired[group.key] {
+			continue
+		}
+		items = append(items, VarianceItem{
+			Key:             group.key,
+			Type:            "missing_record",
+			Currency:        group.currency,
+			AmountsBySource: withOriginalAmounts(amountsBySource(group.amounts, sources), group.originalAmounts),
+			MissingSources:  missingSourcesFor(group, sources),
+		})
+	}
+
+	sortVarianceItems(items)
+	sortMatchItems(matches)
+	for _, item := range items {
+		builder.countItem(item)
+	}
+	for _, match := range matches {
+		builder.countMatch(match)
+	}
+	return items, matches, nil
 }
 
-func stringifyMap(data map[string]any) map[string]string {
-	result := make(map[string]string, len(data))
-	for key, value := range data {
-		switch typed := value.(type) {
-		case string:
-			result[key] = typed
-		case float64:
-			result[key] = strconv.FormatFloat(typed, 'f', -1, 64)
-		case bool:
-			result[key] = strconv.FormatBool(typed)
-		case nil:
-			result[key] = ""
-		default:
-			bytes, _ := json.Marshal(typed)
-			result[key] = string(bytes)
-		}
+func (builder *varianceBuilder) varianceSummary() VarianceSummary {
+	return VarianceSummary{
+		Total:        builder.total,
+		CountsByType: builder.counts,
+		ByCurrency:   builder.byCurrency,
 	}
-	return result
 }
 
-func mapRecord(record map[string]string, source string, ruleset *Ruleset, mapping *MappingConfig) map[string]string {
-	fieldMapping, ok := mapping.Sources[source]
-	if !ok {
-		fieldMapping = FieldMapping{
-			ID:        "id",
-			Amount:    ruleset.AmountField,
-			Currency:  ruleset.CurrencyField,
-			Timestamp: ruleset.TimestampField,
-			Account:   ruleset.AccountField,
-		}
+func (builder *varianceBuilder) matchSummary() MatchSummary {
+	return MatchSummary{
+		Total:        builder.matchTotal,
+		CountsByType: builder.matchCounts,
 	}
+}
 
-	mapped := make(map[string]string, len(record)+2)
-	for key, value := range record {
-		mapped[key] = value
+func varianceItemLess(left *VarianceItem, right *VarianceItem) bool {
+	if left.Key != right.Key {
+		return left.Key < right.Key
 	}
+	return left.Type < right.Type
+}
 
-	if fieldMapping.ID != "" {
-		mapped["id"] = record[fieldMapping.ID]
+func matchItemLess(left *MatchItem, right *MatchItem) bool {
+	if left.Key != right.Key {
+		return left.Key < right.Key
 	}
-	if fieldMapping.Amount != "" {
-		mapped[ruleset.AmountField] = record[fieldMapping.Amount]
+	return left.Type < right.Type
+}
+
+func sortVarianceItems(items []VarianceItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return varianceItemLess(&items[i], &items[j])
+	})
+}
+
+func sortMatchItems(matches []MatchItem) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matchItemLess(&matches[i], &matches[j])
+	})
+}
+
+// applyDuplicatePolicy resolves a run of records that share a key and
+// source. "sum" keeps every record so their amounts add up; "flag" and
+// "first_wins" keep only the earliest record in input order, and "flag" also
+// reports the colliding record IDs as a duplicate_record variance.
+func applyDuplicatePolicy(run []NormalizedRecord, policy string) ([]NormalizedRecord, *VarianceItem) {
+	if policy == "sum" || policy == "" || len(run) < 2 {
+		return run, nil
 	}
-	if fieldMapping.Currency != "" {
-		mapped[ruleset.CurrencyField] = record[fieldMapping.Currency]
+
+	first := 0
+	for index := 1; index < len(run); index++ {
+		if run[index].ordinal < run[first].ordinal {
+			first = index
+		}
 	}
-	if fieldMapping.Timestamp != "" {
-		mapped[ruleset.TimestampField] = record[fieldMapping.Timestamp]
+	kept := run[first : first+1]
+	if policy != "flag" {
+		return kept, nil
 	}
-	if fieldMapping.Account != "" {
-		mapped["account"] = record[fieldMapping.Account]
+
+	ids := make([]string, 0, len(run))
+	for _, record := range run {
+		ids = append(ids, record.ID)
+	}
+	sort.Strings(ids)
+	return kept, &VarianceItem{
+		Key:              run[0].Key,
+		Type:             "duplicate_record",
+		Currency:         run[0].Currency,
+		DuplicateRecords: []SourceRecords{{Source: run[0].Source, RecordIDs: ids}},
 	}
+}
 
-	return mapped
+// comparedFields returns the ruleset compare keys that need a field-level
+// comparison. Amount and currency have dedicated variance types, and match
+// keys agree by construction, so they are skipped.
+func comparedFields(ruleset *Ruleset) []string {
+	skip := map[string]bool{ruleset.AmountField: true, ruleset.CurrencyField: true}
+	for _, field := range ruleset.KeyFields {
+		skip[field] = true
+	}
+	fields := make([]string, 0, len(ruleset.CompareKeys))
+	for _, field := range ruleset.CompareKeys {
+		if !skip[field] {
+			fields = append(fields, field)
+			skip[field] = true
+		}
+	}
+	return fields
 }
 
-func buildKey(record map[string]string, fields []string) (string, []string) {
-	parts := make([]string, 0, len(fields))
-	warnings := make([]string, 0)
+// fieldDifferences lists the compared fields on which the records of a key
+// group disagree, whether across sources or between records of the same
+// source kept under the sum duplicate policy. Each distinct value is listed
+// once per source that has it, so a source with conflicting values appears
+// more than once.
+func fieldDifferences(group *keyGroup, fields []string, sources []string) []FieldDifference {
+	differences := make([]FieldDifference, 0)
 	for _, field := range fields {
-		value := strings.TrimSpace(record[field])
-		if value == "" {
-			warnings = append(warnings, fmt.Sprintf("missing key field %s", field))
-			return "", warnings
+		values := make([]SourceValue, 0, len(sources))
+		differs := false
+		for _, source := range sources {
+			recordFields, ok := group.fields[source]
+			if !ok {
+				continue
+			}
+			for _, value := range recordFields[field] {
+				if len(values) > 0 && value != values[0].Value {
+					differs = true
+				}
+				values = append(values, SourceValue{Source: source, Value: value})
+			}
+		}
+		if differs {
+			differences = append(differences, FieldDifference{Field: field, ValuesBySource: values})
 		}
-		parts = append(parts, fmt.Sprintf("%s=%s", field, value))
 	}
-	return strings.Join(parts, "|"), warnings
+	return differences
 }
 
-func parseAmount(value string, rounding string) (int64, string) {
-	trimmed := strings.TrimSpace(value)
-	if trimmed == "" {
-		return 0, "missing amount"
+// mergedFieldDifferences compares the fields of key groups joined by a fuzzy
+// or aggregate match as though they were one group.
+func mergedFieldDifferences(groups []*keyGroup, fields []string, sources []string) []FieldDifference {
+	if len(fields) == 0 {
+		return nil
+	}
+	merged := &keyGroup{fields: map[string]map[string][]string{}}
+	for _, group := range groups {
+		for source, values := range group.fields {
+			if merged.fields[source] == nil {
+				merged.fields[source] = map[string][]string{}
+			}
+			for field, fieldValues := range values {
+				for _, value := range fieldValues {
+					if !slices.Contains(merged.fields[source][field], value) {
+						merged.fields[source][field] = append(merged.fields[source][field], value)
+					}
+				}
+			}
+		}
 	}
+	return fieldDifferences(merged, fields, sources)
+}
 
-	negative := false
-	if strings.HasPrefix(trimmed, "-") {
-		negative = true
-		trimmed = strings.TrimPrefix(trimmed, "-")
-	}
+// matchTypes lists every match type the engine emits.
+var matchTypes = []string{"exact", "within_tolerance", "one_to_many", "fuzzy_match"}
 
-	parts := strings.SplitN(trimmed, ".", 2)
-	wholePart := parts[0]
-	fractionPart := ""
-	if len(parts) == 2 {
-		fractionPart = parts[1]
+// Match confidences start from the certainty of the match type and fall with
+// how far the match had to stretch: the amount difference against the
+// tolerance, the date gap against the window, or the share of in-window
+// candidates left out of an aggregate. Scores are rounded to four decimals.
+func toleranceConfidence(difference int64, tolerance int64) float64 {
+	if tolerance <= 0 {
+		return 1
 	}
-	if wholePart == "" {
-		wholePart = "0"
+	return roundConfidence(1 - 0.1*float64(difference)/float64(tolerance))
+}
+
+func dateConfidence(deltaDays int, windowDays int) float64 {
+	return roundConfidence(0.9 - 0.1*float64(deltaDays)/float64(windowDays+1))
+}
+
+func aggregateConfidence(chosen int, candidates int) float64 {
+	return roundConfidence(0.9 - 0.1*float64(candidates-chosen)/float64(candidates))
+}
+
+func roundConfidence(confidence float64) float64 {
+	return math.Round(confidence*10000) / 10000
+}
+
+func exactMatch(group *keyGroup, matchType string, sources []string, amounts []SourceAmount) MatchItem {
+	return MatchItem{
+		Key:             group.key,
+		Type:            matchType,
+		Currency:        group.currency,
+		Confidence:      1,
+		MatchedKeys:     []string{group.key},
+		RecordsBySource: recordsBySource(group.recordIDs, sources),
+		AmountsBySource: amounts,
 	}
-	wholeValue, err := strconv.ParseInt(wholePart, 10, 64)
-	if err != nil {
-		return 0, fmt.Sprintf("invalid amount: %s", value)
+}
+
+// withOriginalAmounts records the pre-conversion amounts behind each source
+// total when records were converted into the reporting currency.
+func withOriginalAmounts(amounts []SourceAmount, originalAmounts map[string]map[string]int64) []SourceAmount {
+	for index := range amounts {
+		byCurrency := originalAmounts[amounts[index].Source]
+		currencies := make([]string, 0, len(byCurrency))
+		for currency := range byCurrency {
+			currencies = append(currencies, currency)
+		}
+		sort.Strings(currencies)
+		for _, currency := range currencies {
+			amounts[index].OriginalAmounts = append(amounts[index].OriginalAmounts, CurrencyAmount{
+				Currency:    currency,
+				AmountMinor: byCurrency[currency],
+			})
+		}
 	}
+	return amounts
+}
 
-	fractionDigits := fractionPart
-	for len(fractionDigits) < 3 {
-		fractionDigits += "0"
+func amountsBySource(sourceAmounts map[string]int64, sources []string) []SourceAmount {
+	amounts := make([]SourceAmount, 0, len(sources))
+	for _, source := range sources {
+		if amount, ok := sourceAmounts[source]; ok {
+			amounts = append(amounts, SourceAmount{Source: source, AmountMinor: amount})
+		}
 	}
+	return amounts
+}
 
-	centsDigits := fractionDigits[:2]
-	thirdDigit := fractionDigits[2]
-	remainingDigits := ""
-	if len(fractionDigits) > 3 {
-		remainingDigits = fractionDigits[3:]
+func currencyAmountsBySource(currencyAmounts map[string]map[string]int64, sources []string) []SourceAmount {
+	amounts := make([]SourceAmount, 0, len(sources))
+	for _, source := range sources {
+		currencies := make([]string, 0, len(currencyAmounts[source]))
+		for currency := range currencyAmounts[source] {
+			currencies = append(currencies, currency)
+		}
+		sort.Strings(currencies)
+		for _, currency := range currencies {
+			amounts = append(amounts, SourceAmount{
+				Source:      source,
+				Currency:    currency,
+				AmountMinor: currencyAmounts[source][currency],
+			})
+		}
 	}
+	return amounts
+}
 
-	centsValue, err := strconv.ParseInt(centsDigits, 10, 64)
-	if err != nil {
-		return 0, fmt.Sprintf("invalid amount: %s", value)
+// exposureTypes are the variance types that leave money unreconciled. Only
+// they count towards the per-currency totals: duplicates carry no amounts,
+// fuzzy matches are reconciled, and field mismatches agree on the amount.
+var exposureTypes = map[string]bool{
+	"missing_record":    true,
+	"amount_mismatch":   true,
+	"currency_mismatch": true,
+}
+
+// addCurrencyExposure adds an item's variance exposure to the per-currency
+// totals. An item's exposure is the spread between its source amounts, with
+// missing sources counted as zero. Currency mismatches count towards every
+// currency involved, each with the absolute amounts booked in that currency.
+func addCurrencyExposure(totals map[string]CurrencyVarianceTotals, item VarianceItem, sources []string) {
+	if !exposureTypes[item.Type] {
+		return
 	}
 
-	if thirdDigit != '0' || remainingDigits != "" {
-		switch rounding {
-		case "half_up":
-			if thirdDigit >= '5' {
-				centsValue++
+	exposure := map[string]int64{}
+	if item.Type == "currency_mismatch" {
+		for _, amount := range item.AmountsBySource {
+			exposure[amount.Currency] += absInt64(amount.AmountMinor)
+		}
+	} else {
+		var minAmount, maxAmount int64
+		for index, amount := range item.AmountsBySource {
+			if index == 0 || amount.AmountMinor < minAmount {
+				minAmount = amount.AmountMinor
 			}
-		case "bankers":
-			roundedUp := false
-			if thirdDigit > '5' {
-				centsValue++
-				roundedUp = true
-			} else if thirdDigit == '5' {
-				if hasNonZero(remainingDigits) {
-					centsValue++
-					roundedUp = true
-				} else if centsValue%2 == 1 {
-					centsValue++
-					roundedUp = true
-				}
+			if index == 0 || amount.AmountMinor > maxAmount {
+				maxAmount = amount.AmountMinor
 			}
-			if roundedUp {
-				// no-op, used for clarity
+		}
+		if len(item.AmountsBySource) < len(sources) {
+			if minAmount > 0 {
+				minAmount = 0
+			}
+			if maxAmount < 0 {
+				maxAmount = 0
 			}
 		}
+		exposure[item.Currency] = maxAmount - minAmount
 	}
 
-	if centsValue >= 100 {
-		wholeValue += centsValue / 100
-		centsValue = centsValue % 100
+	for currency, amount := range exposure {
+		current := totals[currency]
+		current.Count++
+		current.TotalAbsoluteVarianceMinorUnits += amount
+		totals[currency] = current
 	}
+}
 
-	result := wholeValue*100 + centsValue
-	if negative {
-		result = -result
+func missingSourcesFor(group *keyGroup, sources []string) []string {
+	missing := make([]string, 0)
+	for _, source := range sources {
+		if _, ok := group.amounts[source]; !ok {
+			missing = append(missing, source)
+		}
 	}
-	return result, ""
+	sort.Strings(missing)
+	return missing
 }
 
-func normalizeTimestamp(value string, location *time.Location) (string, string) {
-	layouts := []string{
-		time.RFC3339,
-		"2006-01-02 15:04:05",
-		"2006-01-02",
+// pairFuzzyMatches pairs key groups left incomplete by exact keying. Two groups
+// pair when they cover disjoint sources that together cover every source,
+// agree on amount, currency and account, and their timestamps fall within
+// windowDays calendar days of each other; a window of 0 pairs same-day
+// records only, and a nil window disables the pass. Candidate pairs are
+// assigned closest-dated first across all groups, ties broken by key, so an
+// earlier key cannot take a candidate that is closer to a later one.
+func pairFuzzyMatches(leftovers []*keyGroup, sources []string, windowDays *int) ([]MatchItem, map[string]bool) {
+	matches := make([]MatchItem, 0)
+	paired := map[string]bool{}
+	if windowDays == nil {
+		return matches, paired
+	}
+
+	type fuzzyGroup struct {
+		group *keyGroup
+		day   int64
+	}
+	type fuzzyPair struct {
+		left, right *keyGroup
+		delta       int
+	}
+	// Only groups agreeing on currency, account and amount can pair, so
+	// candidates are bucketed on those before comparing dates.
+	buckets := map[string][]fuzzyGroup{}
+	bucketKeys := make([]string, 0)
+	for _, group := range leftovers {
+		amount, ok := uniformAmount(group)
+		if !ok {
+			continue
+		}
+		day, ok := calendarDay(group.timestamp)
+		if !ok {
+			continue
+		}
+		bucket := fmt.Sprintf("%s\x00%s\x00%d", group.currency, group.account, amount)
+		if _, ok := buckets[bucket]; !ok {
+			bucketKeys = append(bucketKeys, bucket)
+		}
+		buckets[bucket] = append(buckets[bucket], fuzzyGroup{group: group, day: day})
 	}
-	for _, layout := range layouts {
-		if parsed, err := time.ParseInLocation(layout, value, location); err == nil {
-			return parsed.In(location).Format(time.RFC3339), ""
+
+	pairs := make([]fuzzyPair, 0)
+	for _, bucket := range bucketKeys {
+		candidates := buckets[bucket]
+		for i, left := range candidates {
+			for _, right := range candidates[i+1:] {
+				if !complementarySources(left.group, right.group, sources) {
+					continue
+				}
+				delta := int(absInt64(right.day - left.day))
+				if delta > *windowDays {
+					continue
+				}
+				pairs = append(pairs, fuzzyPair{left: left.group, right: right.group, delta: delta})
+			}
 		}
 	}
-	return value, fmt.Sprintf("unparsed timestamp: %s", value)
-}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].delta != pairs[j].delta {
+			return pairs[i].delta < pairs[j].delta
+		}
+		if pairs[i].left.key != pairs[j].left.key {
+			return pairs[i].left.key < pairs[j].left.key
+		}
+		return pairs[i].right.key < pairs[j].right.key
+	})
 
-func computeVariances(records []NormalizedRecord, sources []string) ([]VarianceItem, VarianceSummary) {
-	byKey := map[string]map[string]int64{}
-	currencyByKey := map[string]string{}
-	for _, record := range records {
-		if _, ok := byKey[record.Key]; !ok {
-			byKey[record.Key] = map[string]int64{}
+	for _, pair := range pairs {
+		group, best := pair.left, pair.right
+		if paired[group.key] || paired[best.key] {
+			continue
 		}
-		byKey[record.Key][record.Source] += record.AmountCents
-		if currencyByKey[record.Key] == "" {
-			currencyByKey[record.Key] = record.Currency
+		paired[group.key] = true
+		paired[best.key] = true
+		merged := map[string]int64{}
+		originals := map[string]map[string]int64{}
+		recordIDs := map[string][]string{}
+		for _, side := range []*keyGroup{group, best} {
+			for source, value := range side.amounts {
+				merged[source] = value
+				originals[source] = side.originalAmounts[source]
+				recordIDs[source] = side.recordIDs[source]
+			}
 		}
+		delta := pair.delta
+		matches = append(matches, MatchItem{
+			Key:             group.key,
+			Type:            "fuzzy_match",
+			Currency:        group.currency,
+			Confidence:      dateConfidence(delta, *windowDays),
+			MatchedKeys:     []string{group.key, best.key},
+			RecordsBySource: recordsBySource(recordIDs, sources),
+			AmountsBySource: withOriginalAmounts(amountsBySource(merged, sources), originals),
+			DateDeltaDays:   &delta,
+		})
 	}
+	return matches, paired
+}
 
-	keys := make([]string, 0, len(byKey))
-	for key := range byKey {
-		keys = append(keys, key)
+// maxAggregateSearchSteps bounds the subset-sum search for one anchor and
+// source, so a window crowded with candidates cannot stall the run.
+const maxAggregateSearchSteps = 1 << 16
+
+// matchAggregates looks for one leftover group whose amount equals the sum of
+// several leftover groups from a single other source, such as a bank payout
+// settling many ledger charges. Candidates must share the anchor's currency and,
+// when configured, its reference value and a timestamp within the rule window.
+// Anchors whose candidates sum to the anchor in full are matched first; the
+// rest then search for a subset of their remaining candidates that does, so
+// an unrelated charge in the window does not block the payout. Consumed keys
+// are recorded in paired.
+func matchAggregates(leftovers []*keyGroup, sources []string, rule *GroupingRule, paired map[string]bool) []MatchItem {
+	matches := make([]MatchItem, 0)
+	for _, exhaustive := range []bool{true, false} {
+		for _, anchor := range leftovers {
+			if paired[anchor.key] {
+				continue
+			}
+			if match, ok := matchAggregate(anchor, leftovers, sources, rule, paired, exhaustive); ok {
+				matches = append(matches, match)
+			}
+		}
 	}
-	sort.Strings(keys)
+	return matches
+}
 
-	items := make([]VarianceItem, 0)
-	counts := map[string]int{"missing_record": 0, "amount_mismatch": 0}
+func matchAggregate(anchor *keyGroup, leftovers []*keyGroup, sources []string, rule *GroupingRule, paired map[string]bool, exhaustive bool) (MatchItem, bool) {
+	anchorSource, ok := singleSource(anchor)
+	if !ok {
+		return MatchItem{}, false
+	}
+	anchorDay, hasAnchorDay := calendarDay(anchor.timestamp)
+	if rule.WindowDays > 0 && !hasAnchorDay {
+		return MatchItem{}, false
+	}
 
-	for _, key := range keys {
-		sourceAmounts := byKey[key]
-		missingSources := make([]string, 0)
-		amounts := make([]SourceAmount, 0, len(sources))
-		for _, source := range sources {
-			amount, ok := sourceAmounts[source]
-			if !ok {
-				missingSources = append(missingSources, source)
+	candidatesBySource := map[string][]*keyGroup{}
+	for _, candidate := range leftovers {
+		if candidate == anchor || paired[candidate.key] {
+			continue
+		}
+		candidateSource, ok := singleSource(candidate)
+		if !ok || candidateSource == anchorSource || candidate.currency != anchor.currency {
+			continue
+		}
+		if rule.ReferenceField != "" && (anchor.reference == "" || candidate.reference != anchor.reference) {
+			continue
+		}
+		if rule.WindowDays > 0 {
+			candidateDay, ok := calendarDay(candidate.timestamp)
+			if !ok || absInt64(candidateDay-anchorDay) > int64(rule.WindowDays) {
 				continue
 			}
-			amounts = append(amounts, SourceAmount{Source: source, AmountCents: amount})
 		}
-		sort.Strings(missingSources)
+		candidatesBySource[candidateSource] = append(candidatesBySource[candidateSource], candidate)
+	}
 
-		if len(missingSources) > 0 {
-			items = append(items, VarianceItem{
-				Key:             key,
-				Type:            "missing_record",
-				Currency:        currencyByKey[key],
-				AmountsBySource: amounts,
-				MissingSources:  missingSources,
-			})
-			counts["missing_record"]++
+	target := anchor.amounts[anchorSource]
+	for _, source := range sources {
+		candidates := candidatesBySource[source]
+		if len(candidates) < 2 {
 			continue
 		}
-
-		amountValues := make([]int64, 0, len(amounts))
-		for _, amount := range amounts {
-			amountValues = append(amountValues, amount.AmountCents)
+		amounts := make([]int64, len(candidates))
+		var total int64
+		for index, candidate := range candidates {
+			amounts[index] = candidate.amounts[source]
+			total += amounts[index]
 		}
-		allEqual := true
-		for i := 1; i < len(amountValues); i++ {
-			if amountValues[i] != amountValues[0] {
-				allEqual = false
-				break
+		var chosen []int
+		switch {
+		case total == target:
+			chosen = make([]int, len(candidates))
+			for index := range chosen {
+				chosen[index] = index
 			}
+		case !exhaustive:
+			chosen = aggregateSubset(amounts, target)
 		}
-		if !allEqual {
-			items = append(items, VarianceItem{
-				Key:             key,
-				Type:            "amount_mismatch",
-				Currency:        currencyByKey[key],
-				AmountsBySource: amounts,
-			})
-			counts["amount_mismatch"]++
+		if len(chosen) < 2 {
+			continue
+		}
+
+		paired[anchor.key] = true
+		keys := []string{anchor.key}
+		recordIDs := map[string][]string{anchorSource: append([]string{}, anchor.recordIDs[anchorSource]...)}
+		for _, index := range chosen {
+			candidate := candidates[index]
+			paired[candidate.key] = true
+			keys = append(keys, candidate.key)
+			recordIDs[source] = append(recordIDs[source], candidate.recordIDs[source]...)
+		}
+		sort.Strings(keys)
+		sort.Strings(recordIDs[source])
+
+		return MatchItem{
+			Key:             anchor.key,
+			Type:            "one_to_many",
+			Currency:        anchor.currency,
+			Confidence:      aggregateConfidence(len(chosen), len(candidates)),
+			Reference:       anchor.reference,
+			AnchorSource:    anchorSource,
+			MatchedKeys:     keys,
+			RecordsBySource: recordsBySource(recordIDs, sources),
+			AmountsBySource: amountsBySource(map[string]int64{anchorSource: target, source: target}, sources),
+		}, true
+	}
+	return MatchItem{}, false
+}
+
+// aggregateSubset returns the indexes of at least two amounts summing to
+// target, or nil. Amounts are tried in order, including each before skipping
+// it, so the first subset in key order wins. The search gives up after
+// maxAggregateSearchSteps.
+func aggregateSubset(amounts []int64, target int64) []int {
+	// Suffix bounds on what the remaining amounts can still add prune
+	// branches that can no longer reach the target.
+	positive := make([]int64, len(amounts)+1)
+	negative := make([]int64, len(amounts)+1)
+	for index := len(amounts) - 1; index >= 0; index-- {
+		positive[index], negative[index] = positive[index+1], negative[index+1]
+		if amounts[index] > 0 {
+			positive[index] += amounts[index]
+		} else {
+			negative[index] += amounts[index]
 		}
 	}
 
-	sort.Slice(items, func(i, j int) bool {
-		if items[i].Key != items[j].Key {
-			return items[i].Key < items[j].Key
+	steps := 0
+	chosen := make([]int, 0, len(amounts))
+	var search func(index int, sum int64) bool
+	search = func(index int, sum int64) bool {
+		if sum == target && len(chosen) >= 2 {
+			return true
 		}
-		return items[i].Type < items[j].Type
-	})
+		steps++
+		if index == len(amounts) || steps > maxAggregateSearchSteps {
+			return false
+		}
+		if sum+positive[index] < target || sum+negative[index] > target {
+			return false
+		}
+		chosen = append(chosen, index)
+		if search(index+1, sum+amounts[index]) {
+			return true
+		}
+		chosen = chosen[:len(chosen)-1]
+		return search(index+1, sum)
+	}
+	if !search(0, 0) {
+		return nil
+	}
+	return chosen
+}
+
+func singleSource(group *keyGroup) (string, bool) {
+	if len(group.amounts) != 1 {
+		return "", false
+	}
+	for source := range group.amounts {
+		return source, true
+	}
+	return "", false
+}
 
-	summary := VarianceSummary{
-		Total:        counts["missing_record"] + counts["amount_mismatch"],
-		CountsByType: counts,
+func recordsBySource(recordIDs map[string][]string, sources []string) []SourceRecords {
+	records := make([]SourceRecords, 0, len(sources))
+	for _, source := range sources {
+		if ids, ok := recordIDs[source]; ok {
+			records = append(records, SourceRecords{Source: source, RecordIDs: ids})
+		}
 	}
+	return records
+}
 
-	return items, summary
+func uniformSourceAmounts(amounts []SourceAmount) bool {
+	for _, amount := range amounts {
+		if amount.AmountMinor != amounts[0].AmountMinor {
+			return false
+		}
+	}
+	return true
 }
 
-func writeJSONLines(path string, records any) error {
-	file, err := os.Create(path)
+func uniformAmount(group *keyGroup) (int64, bool) {
+	first := true
+	var amount int64
+	for _, value := range group.amounts {
+		if first {
+			amount = value
+			first = false
+			continue
+		}
+		if value != amount {
+			return 0, false
+		}
+	}
+	return amount, !first
+}
+
+func complementarySources(left *keyGroup, right *keyGroup, sources []string) bool {
+	for _, source := range sources {
+		_, inLeft := left.amounts[source]
+		_, inRight := right.amounts[source]
+		if inLeft == inRight {
+			return false
+		}
+	}
+	return true
+}
+
+// calendarDay returns the number of days since the Unix epoch for the local
+// calendar date of a normalized timestamp.
+func calendarDay(timestamp string) (int64, bool) {
+	parsed, err := time.Parse(time.RFC3339, timestamp)
 	if err != nil {
-		return fmt.Errorf("create %s: %w", path, err)
+		return 0, false
 	}
-	defer file.Close()
+	year, month, day := parsed.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Unix() / 86400, true
+}
+
+// toleranceFor returns the allowed spread in minor units for a group whose
+// amounts range from minAmount to maxAmount. The percentage tolerance is taken
+// against the largest absolute amount and the wider of the two rules applies.
+func toleranceFor(ruleset *Ruleset, minAmount int64, maxAmount int64) int64 {
+	tolerance := ruleset.ToleranceMinorUnits
+	if ruleset.TolerancePercent <= 0 {
+		return tolerance
+	}
+
+	base := absInt64(maxAmount)
+	if absInt64(minAmount) > base {
+		base = absInt64(minAmount)
+	}
+	percent, ok := new(big.Rat).SetString(strconv.FormatFloat(ruleset.TolerancePercent, 'f', -1, 64))
+	if !ok {
+		return tolerance
+	}
+	allowed := new(big.Rat).Mul(new(big.Rat).SetInt64(base), percent)
+	allowed.Quo(allowed, big.NewRat(100, 1))
+	percentTolerance := new(big.Int).Quo(allowed.Num(), allowed.Denom()).Int64()
+	if percentTolerance > tolerance {
+		tolerance = percentTolerance
+	}
+	return tolerance
+}
 
-	writer := bufio.NewWriter(file)
-	encoder := json.NewEncoder(writer)
+// jsonLinesWriter writes one JSON document per line as records arrive.
+type jsonLinesWriter struct {
+	file    *os.File
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+func newJSONLinesWriter(path string) (*jsonLinesWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", path, err)
+	}
+	buffer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(buffer)
 	encoder.SetEscapeHTML(false)
+	return &jsonLinesWriter{file: file, buffer: buffer, encoder: encoder}, nil
+}
 
-	switch typed := records.(type) {
-	case []NormalizedRecord:
-		for _, record := range typed {
-			if err := encoder.Encode(record); err != nil {
-				return fmt.Errorf("write jsonl: %w", err)
-			}
-		}
-		return writer.Flush()
-	case []VarianceItem:
-		for _, record := range typed {
-			if err := encoder.Encode(record); err != nil {
-				return fmt.Errorf("write jsonl: %w", err)
-			}
-		}
-		return writer.Flush()
-	default:
-		return errors.New("unsupported jsonl record type")
+func (writer *jsonLinesWriter) write(record any) error {
+	if err := writer.encoder.Encode(record); err != nil {
+		return fmt.Errorf("write jsonl: %w", err)
+	}
+	return nil
+}
+
+func (writer *jsonLinesWriter) close() error {
+	flushErr := writer.buffer.Flush()
+	closeErr := writer.file.Close()
+	if flushErr != nil {
+		return flushErr
 	}
+	return closeErr
 }
 
 func writeJSONFile(path string, data any) error {
@@ -747,6 +3058,16 @@ func writeJSONFile(path string, data any) error {
 	return nil
 }
 
+// canonicalRulesetHash hashes the ruleset as loaded, after defaults are applied,
+// so equivalent JSON, YAML and contract-format rulesets share one hash.
+func canonicalRulesetHash(ruleset *Ruleset) (string, error) {
+	canonical, err := json.Marshal(ruleset)
+	if err != nil {
+		return "", fmt.Errorf("encode ruleset: %w", err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(canonical)), nil
+}
+
 func hashFile(path string) (string, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -762,19 +3083,37 @@ func hashFile(path string) (string, error) {
 }
 
 func buildDeterministicStatement(input EngineInput) string {
+	rounding := input.Determinism.Rounding
+	if input.RoundingIncrement > 1 {
+		rounding = fmt.Sprintf("%s to increments of %d minor units", rounding, input.RoundingIncrement)
+	}
 	return fmt.Sprintf(
 		"Outputs are deterministic for identical inputs when using sort keys %s, rounding mode %s, and timezone %s. The engine surfaces discrepancies based on the normalized inputs; evidence hashes cover emitted files.",
 		strings.Join(input.Determinism.SortKeys, ", "),
-		input.Determinism.Rounding,
+		rounding,
 		input.Determinism.Timezone,
 	)
 }
 
-func hasNonZero(value string) bool {
+func absInt64(value int64) int64 {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+func isDigits(value string) bool {
+	if value == "" {
+		return false
+	}
 	for _, char := range value {
-		if char != '0' {
-			return true
+		if char < '0' || char > '9' {
+			return false
 		}
 	}
-	return false
+	return true
+}
+
+func pow10(exponent int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
 }
@@ -1,17 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 )
 
 type ExpectedCounts struct {
 	MissingRecord  int `json:"missing_record"`
 	AmountMismatch int `json:"amount_mismatch"`
+	Exact          int `json:"exact"`
 }
 
 type Schema struct {
@@ -83,10 +89,21 @@ func TestFixtureRun(t *testing.T) {
 		t.Fatalf("amount_mismatch count mismatch: got %d want %d", output.VarianceSummary.CountsByType["amount_mismatch"], expected.AmountMismatch)
 	}
 
+	if output.MatchSummary.CountsByType["exact"] != expected.Exact {
+		t.Fatalf("exact match count mismatch: got %d want %d", output.MatchSummary.CountsByType["exact"], expected.Exact)
+	}
+
 	manifestPath := filepath.Join(outputDir, "evidence", "manifest.json")
 	if _, err := os.Stat(manifestPath); err != nil {
 		t.Fatalf("manifest not created: %v", err)
 	}
+	manifested := map[string]bool{}
+	for _, file := range output.EvidenceManifest.Files {
+		manifested[file.Path] = true
+	}
+	if !manifested[filepath.Join("evidence", "matches.jsonl")] {
+		t.Fatalf("matches.jsonl missing from evidence manifest")
+	}
 
 	secondOutputDir := filepath.Join(t.TempDir(), "output")
 	input.OutputDir = secondOutputDir
@@ -158,3 +175,464 @@ func hashFileForTest(path string) (string, error) {
 	digest := sha256.Sum256(data)
 	return hex.EncodeToString(digest[:]), nil
 }
+
+// runFixture runs the engine against fixtures/<name>/engine_input.json with
+// relative paths resolved against the fixture directory and output written to
+// a temporary directory.
+func runFixture(t *testing.T, name string) (*EngineOutput, string) {
+	t.Helper()
+	return runFixtureWith(t, name, nil)
+}
+
+// runFixtureWith is runFixture with adjust applied to the engine input first.
+func runFixtureWith(t *testing.T, name string, adjust func(*EngineInput)) (*EngineOutput, string) {
+	t.Helper()
+
+	inputPath := prepareFixture(t, name, adjust)
+	output, err := RunEngine(inputPath)
+	if err != nil {
+		t.Fatalf("run engine: %v", err)
+	}
+	return output, filepath.Dir(inputPath)
+}
+
+// prepareFixture writes the fixture's engine input, adjusted and with paths
+// made absolute, into a temporary output directory and returns its path.
+func prepareFixture(t *testing.T, name string, adjust func(*EngineInput)) string {
+	t.Helper()
+
+	fixtureDir, err := filepath.Abs(filepath.Join("fixtures", name))
+	if err != nil {
+		t.Fatalf("resolve fixture dir: %v", err)
+	}
+	inputBytes, err := os.ReadFile(filepath.Join(fixtureDir, "engine_input.json"))
+	if err != nil {
+		t.Fatalf("read fixture input: %v", err)
+	}
+	var input EngineInput
+	if err := json.Unmarshal(inputBytes, &input); err != nil {
+		t.Fatalf("parse fixture input: %v", err)
+	}
+
+	input.InputFiles = resolvePaths(fixtureDir, input.InputFiles)
+	input.RulesetPath = resolvePath(fixtureDir, input.RulesetPath)
+	for _, path := range []*string{input.MappingConfigPath, input.FXRatesPath} {
+		if path != nil && *path != "" {
+			*path = resolvePath(fixtureDir, *path)
+		}
+	}
+	outputDir := filepath.Join(t.TempDir(), "output")
+	input.OutputDir = outputDir
+	if adjust != nil {
+		adjust(&input)
+	}
+
+	updatedBytes, err := json.Marshal(input)
+	if err != nil {
+		t.Fatalf("marshal input: %v", err)
+	}
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		t.Fatalf("create temp output dir: %v", err)
+	}
+	updatedInputPath := filepath.Join(outputDir, "engine_input.json")
+	if err := os.WriteFile(updatedInputPath, updatedBytes, 0o644); err != nil {
+		t.Fatalf("write temp input: %v", err)
+	}
+	return updatedInputPath
+}
+
+func readVarianceItems(t *testing.T, outputDir string) []VarianceItem {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "evidence", "variances.jsonl"))
+	if err != nil {
+		t.Fatalf("read variances: %v", err)
+	}
+	items := make([]VarianceItem, 0)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		var item VarianceItem
+		if err := decoder.Decode(&item); err != nil {
+			t.Fatalf("parse variance item: %v", err)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func TestFXFixtureRun(t *testing.T) {
+	output, outputDir := runFixture(t, "fx")
+
+	if output.MatchSummary.CountsByType["exact"] != 1 {
+		t.Fatalf("exact match count mismatch: got %d want 1", output.MatchSummary.CountsByType["exact"])
+	}
+	items := readVarianceItems(t, outputDir)
+	if len(items) != 1 || items[0].Type != "amount_mismatch" {
+		t.Fatalf("expected one amount_mismatch, got %+v", items)
+	}
+	processor := items[0].AmountsBySource[1]
+	if processor.AmountMinor != 5501 || len(processor.OriginalAmounts) != 1 {
+		t.Fatalf("unexpected converted amount: %+v", processor)
+	}
+	if original := processor.OriginalAmounts[0]; original.Currency != "EUR" || original.AmountMinor != 5001 {
+		t.Fatalf("unexpected original amount: %+v", original)
+	}
+	if len(output.EvidenceManifest.Inputs) != 3 {
+		t.Fatalf("input files and fx rates not hashed into manifest: %+v", output.EvidenceManifest.Inputs)
+	}
+	for index, name := range []string{"ledger.csv", "processor.csv", "rates.csv"} {
+		if hashed := output.EvidenceManifest.Inputs[index]; !strings.HasSuffix(hashed.Path, name) || hashed.SHA256 == "" {
+			t.Fatalf("%s not hashed into manifest: %+v", name, hashed)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "evidence", "normalized.jsonl"))
+	if err != nil {
+		t.Fatalf("read normalized records: %v", err)
+	}
+	if !strings.Contains(string(data), `"fx_rounding":{"mode":"bankers","increment_minor_units":1,"raw_value":"55.011"}`) {
+		t.Fatalf("conversion rounding not recorded: %s", data)
+	}
+}
+
+func TestFXSkipsRecordsWithoutValidDate(t *testing.T) {
+	processor := writeTempFile(t, "processor.csv", "transaction_id,amount,currency,timestamp\n1,100.00,EUR,2024-03-01\n2,50.01,EUR,2024-03-02x\n")
+	output, outputDir := runFixtureWith(t, "fx", func(input *EngineInput) {
+		input.InputFiles[1] = processor
+	})
+	warned := false
+	for _, warning := range output.NormalizationSummary.Warnings {
+		if strings.Contains(warning, "transaction_id=2 has no valid date for an fx rate; left in EUR") {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Fatalf("missing fx date warning: %v", output.NormalizationSummary.Warnings)
+	}
+	items := readVarianceItems(t, outputDir)
+	if len(items) != 1 || items[0].Type != "currency_mismatch" || items[0].Key != "transaction_id=2" {
+		t.Fatalf("record with an unparsed timestamp should not be converted: %+v", items)
+	}
+}
+
+func TestContractRulesetFixtureRun(t *testing.T) {
+	output, _ := runFixture(t, "contract")
+
+	if output.VarianceSummary.CountsByType["missing_record"] != 2 {
+		t.Fatalf("missing_record count mismatch: got %d want 2", output.VarianceSummary.CountsByType["missing_record"])
+	}
+	if output.MatchSummary.CountsByType["within_tolerance"] != 1 {
+		t.Fatalf("within_tolerance count mismatch: got %d want 1", output.MatchSummary.CountsByType["within_tolerance"])
+	}
+	if _, ok := output.VarianceSummary.CountsByType["within_tolerance"]; ok {
+		t.Fatalf("within_tolerance should not be counted as a variance: %v", output.VarianceSummary.CountsByType)
+	}
+	if !strings.Contains(output.DeterministicStatement, "rounding mode nearest") {
+		t.Fatalf("ruleset rounding not applied: %s", output.DeterministicStatement)
+	}
+
+	_, err := RunEngine(prepareFixture(t, "contract", func(input *EngineInput) {
+		input.RoundingMode = "bankers"
+		input.Timezone = "America/New_York"
+	}))
+	if err == nil || !strings.Contains(err.Error(), "rounding_mode bankers (ruleset has nearest)") || !strings.Contains(err.Error(), "timezone America/New_York (ruleset has UTC)") {
+		t.Fatalf("conflicting engine input should be rejected, got %v", err)
+	}
+
+	output, _ = runFixtureWith(t, "contract", func(input *EngineInput) {
+		input.RoundingMode = ""
+		input.Determinism.Rounding = ""
+	})
+	if !strings.Contains(output.DeterministicStatement, "rounding mode nearest") {
+		t.Fatalf("ruleset rounding should fill in unset input settings: %s", output.DeterministicStatement)
+	}
+
+	fixtureDir, err := filepath.Abs(filepath.Join("fixtures", "contract"))
+	if err != nil {
+		t.Fatalf("resolve fixture dir: %v", err)
+	}
+	minimal, err := json.Marshal(map[string]any{
+		"input_files":  []string{filepath.Join(fixtureDir, "source_a.csv"), filepath.Join(fixtureDir, "source_b.json")},
+		"input_format": "auto",
+		"ruleset_path": filepath.Join(fixtureDir, "ruleset.json"),
+		"output_dir":   filepath.Join(t.TempDir(), "output"),
+		"mode":         "local",
+	})
+	if err != nil {
+		t.Fatalf("marshal input: %v", err)
+	}
+	minimalPath := writeTempFile(t, "engine_input.json", string(minimal))
+	assertSchemaRequired(t, filepath.Join("schemas", "engine_input.schema.json"), minimalPath)
+	output, err = RunEngine(minimalPath)
+	if err != nil || !strings.Contains(output.DeterministicStatement, "rounding mode nearest") {
+		t.Fatalf("input leaving settings to the ruleset should run: %v", err)
+	}
+}
+
+func TestSpilledSortMatchesInMemory(t *testing.T) {
+	for _, name := range []string{"basic", "fx", "contract"} {
+		_, inMemoryDir := runFixture(t, name)
+		_, spilledDir := runFixtureWith(t, name, func(input *EngineInput) {
+			input.MaxRecordsInMemory = 1
+		})
+		for _, relPath := range []string{
+			filepath.Join("evidence", "normalized.jsonl"),
+			filepath.Join("evidence", "variances.jsonl"),
+			filepath.Join("evidence", "matches.jsonl"),
+			filepath.Join("evidence", "manifest.json"),
+		} {
+			want, err := os.ReadFile(filepath.Join(inMemoryDir, relPath))
+			if err != nil {
+				t.Fatalf("%s: read in-memory output: %v", name, err)
+			}
+			got, err := os.ReadFile(filepath.Join(spilledDir, relPath))
+			if err != nil {
+				t.Fatalf("%s: read spilled output: %v", name, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%s: %s differs when sorted runs spill to disk", name, relPath)
+			}
+		}
+	}
+}
+
+func TestPrecisionFixtureRun(t *testing.T) {
+	output, outputDir := runFixture(t, "precision")
+
+	if output.VarianceSummary.Total != 0 {
+		t.Fatalf("json amounts lost precision: %+v", readVarianceItems(t, outputDir))
+	}
+	if output.MatchSummary.CountsByType["exact"] != 4 {
+		t.Fatalf("exact match count mismatch: got %d want 4", output.MatchSummary.CountsByType["exact"])
+	}
+}
+
+func TestRejectedRowsFixtureRun(t *testing.T) {
+	output, outputDir := runFixture(t, "rejected")
+
+	if output.NormalizationSummary.RecordsProcessed != 3 || output.NormalizationSummary.RecordsSkipped != 4 {
+		t.Fatalf("unexpected normalization summary: %+v", output.NormalizationSummary)
+	}
+	data, err := os.ReadFile(filepath.Join(outputDir, output.RejectedRowsPath))
+	if err != nil {
+		t.Fatalf("read rejected rows: %v", err)
+	}
+	rows := make([]RejectedRow, 0)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		var row RejectedRow
+		if err := decoder.Decode(&row); err != nil {
+			t.Fatalf("parse rejected row: %v", err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("expected 4 rejected rows, got %+v", rows)
+	}
+	if rows[0].Line != 3 || rows[0].Raw != "2,20.00,USD" || rows[0].Reason != "expected 4 fields, got 3" {
+		t.Fatalf("unexpected short row rejection: %+v", rows[0])
+	}
+	if rows[1].Line != 4 || rows[1].Reason != "missing key field transaction_id" || !strings.HasSuffix(rows[1].File, "ledger.csv") {
+		t.Fatalf("unexpected missing key rejection: %+v", rows[1])
+	}
+	if rows[2].Line != 5 || rows[2].Raw != `5,1"0,USD,2024-03-05` || !strings.Contains(rows[2].Reason, "bare \" in non-quoted-field") {
+		t.Fatalf("unexpected stray quote rejection: %+v", rows[2])
+	}
+	if rows[3].Source != "processor" || rows[3].Line != 2 || !strings.HasPrefix(rows[3].Reason, "malformed json") {
+		t.Fatalf("unexpected malformed line rejection: %+v", rows[3])
+	}
+
+	_, err = RunEngine(prepareFixture(t, "rejected", func(input *EngineInput) {
+		input.Strict = true
+	}))
+	if err == nil || !strings.Contains(err.Error(), "line 3 rejected: expected 4 fields, got 3") {
+		t.Fatalf("strict mode should fail on the short row, got %v", err)
+	}
+}
+
+func TestSourcesReadManyFiles(t *testing.T) {
+	dailyDir := t.TempDir()
+	header := "transaction_id,amount,currency,timestamp,account\n"
+	days := map[string]string{
+		"day-01.csv": header + "1,100.00,USD,2024-01-01T00:00:00Z,acct-1\n",
+		"day-02.csv": header + "2,50.25,USD,2024-01-02T00:00:00Z,acct-1\n3,10.00,USD,2024-01-03T00:00:00Z,acct-1\n",
+	}
+	for name, content := range days {
+		if err := os.WriteFile(filepath.Join(dailyDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	output, outputDir := runFixtureWith(t, "basic", func(input *EngineInput) {
+		input.Sources = []SourceInput{
+			{Name: "source_a", Files: []string{filepath.Join(dailyDir, "day-*.csv")}},
+			{Name: "source_b", Files: input.InputFiles[1:]},
+		}
+		input.InputFiles = nil
+	})
+	if output.VarianceSummary.CountsByType["missing_record"] != 2 || output.VarianceSummary.CountsByType["amount_mismatch"] != 1 {
+		t.Fatalf("unexpected variance counts: %+v", output.VarianceSummary.CountsByType)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputDir, "evidence", "normalized.jsonl"))
+	if err != nil {
+		t.Fatalf("read normalized records: %v", err)
+	}
+	origins := make(map[string]string)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for decoder.More() {
+		var record NormalizedRecord
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("parse normalized record: %v", err)
+		}
+		if record.Source == "source_a" {
+			origins[record.Key] = fmt.Sprintf("%s:%d", filepath.Base(record.File), record.Line)
+		}
+	}
+	want := map[string]string{"transaction_id=1": "day-01.csv:2", "transaction_id=2": "day-02.csv:2", "transaction_id=3": "day-02.csv:3"}
+	if fmt.Sprint(origins) != fmt.Sprint(want) {
+		t.Fatalf("unexpected record origins: got %v want %v", origins, want)
+	}
+
+	inputPath := prepareFixture(t, "basic", func(input *EngineInput) {
+		input.Sources = []SourceInput{
+			{Name: "source_a", Files: []string{filepath.Join(dailyDir, "day-*.json")}},
+			{Name: "source_b", Files: input.InputFiles[1:]},
+		}
+		input.InputFiles = nil
+	})
+	if _, err := RunEngine(inputPath); err == nil || !strings.Contains(err.Error(), "matched no files") {
+		t.Fatalf("expected unmatched pattern error, got %v", err)
+	}
+}
+
+func TestXLSXDatesReadInRunTimezone(t *testing.T) {
+	header := `<row r="1"><c r="A1" t="inlineStr"><is><t>transaction_id</t></is></c><c r="B1" t="inlineStr"><is><t>amount</t></is></c>` +
+		`<c r="C1" t="inlineStr"><is><t>currency</t></is></c><c r="D1" t="inlineStr"><is><t>timestamp</t></is></c></row>`
+	row := `<row r="2"><c r="A2"><v>1</v></c><c r="B2"><v>100</v></c><c r="C2" t="inlineStr"><is><t>USD</t></is></c><c r="D2" s="1"><v>45352.75</v></c></row>`
+	styles := `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><cellXfs count="2"><xf numFmtId="0"/><xf numFmtId="22"/></cellXfs></styleSheet>`
+	sharedStrings := `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"></sst>`
+	workbook, err := io.ReadAll(buildXLSX(t, map[string]string{"Summary": header + row}, sharedStrings, styles))
+	if err != nil {
+		t.Fatalf("read workbook: %v", err)
+	}
+	ledger := writeTempFile(t, "source_a.xlsx", string(workbook))
+	_, outputDir := runFixtureWith(t, "basic", func(input *EngineInput) {
+		input.InputFiles[0] = ledger
+		input.Timezone = "America/New_York"
+		input.Determinism.Timezone = "America/New_York"
+	})
+	data, err := os.ReadFile(filepath.Join(outputDir, "evidence", "normalized.jsonl"))
+	if err != nil {
+		t.Fatalf("read normalized records: %v", err)
+	}
+	if !strings.Contains(string(data), `"timestamp":"2024-03-01T18:00:00-05:00"`) {
+		t.Fatalf("workbook wall-clock time should be read in the run timezone: %s", data)
+	}
+}
+
+func TestCurrencyCodesNormalized(t *testing.T) {
+	ledger := writeTempFile(t, "source_a.csv", "transaction_id,amount,currency,timestamp,account\n1,100.00, usd ,2024-01-01T00:00:00Z,acct-1\n2,50.25,Usd,2024-01-02T00:00:00Z,acct-1\n")
+	output, _ := runFixtureWith(t, "basic", func(input *EngineInput) {
+		input.InputFiles[0] = ledger
+	})
+	if output.VarianceSummary.CountsByType["currency_mismatch"] != 0 || output.MatchSummary.CountsByType["exact"] != 1 {
+		t.Fatalf("currency codes should compare case-insensitively: %+v %+v", output.VarianceSummary.CountsByType, output.MatchSummary.CountsByType)
+	}
+}
+
+func TestCompareKeysUseMappedAccount(t *testing.T) {
+	ledger := writeTempFile(t, "ledger.csv", "transaction_id,amount,currency,timestamp,acct\n1,100.00,USD,2024-01-01T00:00:00Z,acct-2\n2,50.24,USD,2024-01-02T00:00:00Z,acct-1\n")
+	ruleset := writeTempFile(t, "ruleset.json", `{"schema_version": "1.0.0", "sources": ["source_a", "source_b"], "key_fields": ["transaction_id"], "amount_field": "amount", "currency_field": "currency", "timestamp_field": "timestamp", "account_field": "account_no", "compare_keys": ["account_no"]}`)
+	_, outputDir := runFixtureWith(t, "basic", func(input *EngineInput) {
+		input.RulesetPath = ruleset
+		input.Sources = []SourceInput{
+			{Name: "source_a", Files: []string{ledger}, Mapping: &FieldMapping{ID: "transaction_id", Account: "acct"}},
+			{Name: "source_b", Files: input.InputFiles[1:], Mapping: &FieldMapping{ID: "transaction_id", Account: "account"}},
+		}
+		input.InputFiles = nil
+	})
+	mismatches := make([]VarianceItem, 0)
+	for _, item := range readVarianceItems(t, outputDir) {
+		if item.Type == "field_mismatch" {
+			mismatches = append(mismatches, item)
+		}
+	}
+	if len(mismatches) != 1 || mismatches[0].Key != "transaction_id=2" || mismatches[0].FieldDifferences[0].Field != "account_no" ||
+		mismatches[0].FieldDifferences[0].ValuesBySource[0].Value != "acct-1" || mismatches[0].FieldDifferences[0].ValuesBySource[1].Value != "acct-2" {
+		t.Fatalf("accounts should be compared through the mapping: %+v", mismatches)
+	}
+}
+
+func TestSourceBindings(t *testing.T) {
+	ledger := writeTempFile(t, "ledger.txt", "transaction_id;total;ccy;posted;acct\n1;100.00;USD;2024-01-01T00:00:00Z;acct-1\n2;50.25;USD;2024-01-02T00:00:00Z;acct-1\n3;10.00;USD;2024-01-03T00:00:00Z;acct-1\n")
+	output, _ := runFixtureWith(t, "basic", func(input *EngineInput) {
+		input.Sources = []SourceInput{
+			{Name: "source_b", Files: input.InputFiles[1:]},
+			{
+				Name:   "source_a",
+				Files:  []string{ledger},
+				Format: "csv",
+				Mapping: &FieldMapping{
+					ID:        "transaction_id",
+					Amount:    "total",
+					Currency:  "ccy",
+					Timestamp: "posted",
+					Account:   "acct",
+					CSV:       &CSVDialect{Delimiter: ";"},
+				},
+			},
+		}
+		input.InputFiles = nil
+	})
+	if output.VarianceSummary.CountsByType["missing_record"] != 2 || output.VarianceSummary.CountsByType["amount_mismatch"] != 1 {
+		t.Fatalf("unexpected variance counts: %+v", output.VarianceSummary.CountsByType)
+	}
+
+	shared := writeTempFile(t, "shared.json", `{"sources": {"source_a": {"id": "transaction_id"}, "source_b": {"id": "transaction_id"}, "source_c": {"amount": "total"}}}`)
+	output, _ = runFixtureWith(t, "basic", func(input *EngineInput) {
+		input.MappingConfigPath = &shared
+	})
+	if !slices.Contains(output.NormalizationSummary.Warnings, "mapping config source source_c is not read by this run; ignored") {
+		t.Fatalf("unused mapping source should only warn: %v", output.NormalizationSummary.Warnings)
+	}
+
+	mappingPath := writeTempFile(t, "mapping.json", `{"sources": {"source_c": {"amount": "total"}}}`)
+	cases := []struct {
+		name   string
+		adjust func(*EngineInput)
+		want   string
+	}{
+		{"file count differs from ruleset sources", func(input *EngineInput) {
+			input.InputFiles = input.InputFiles[:1]
+		}, "ruleset defines 2 sources"},
+		{"source missing from ruleset", func(input *EngineInput) {
+			input.Sources = []SourceInput{
+				{Name: "source_a", Files: input.InputFiles[:1]},
+				{Name: "source_b", Files: input.InputFiles[1:]},
+				{Name: "source_c", Files: input.InputFiles[1:]},
+			}
+			input.InputFiles = nil
+		}, "source source_c is not defined in the ruleset"},
+		{"ruleset source without files", func(input *EngineInput) {
+			input.Sources = []SourceInput{{Name: "source_a", Files: input.InputFiles[:1]}}
+			input.InputFiles = nil
+		}, "ruleset source source_b has no entry in sources"},
+		{"mapping only for an unknown source", func(input *EngineInput) {
+			input.MappingConfigPath = &mappingPath
+		}, "sources source_a, source_b have no mapping, but the mapping config has source_c"},
+		{"unsupported source format", func(input *EngineInput) {
+			input.Sources = []SourceInput{
+				{Name: "source_a", Files: input.InputFiles[:1], Format: "parquet"},
+				{Name: "source_b", Files: input.InputFiles[1:]},
+			}
+			input.InputFiles = nil
+		}, "source source_a: unsupported format: parquet"},
+	}
+	for _, tc := range cases {
+		_, err := RunEngine(prepareFixture(t, "basic", tc.adjust))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected error containing %q, got %v", tc.name, tc.want, err)
+		}
+	}
+}
@@ -0,0 +1,932 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// computeVariances classifies records into variance and match items ordered
+// by key and type, sorting the records first as the engine's sorter does.
+func computeVariances(t *testing.T, records []NormalizedRecord, sources []string, ruleset *Ruleset) ([]VarianceItem, []MatchItem, VarianceSummary) {
+	t.Helper()
+	sorted := append([]NormalizedRecord{}, records...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return recordLess(&sorted[i], &sorted[j])
+	})
+
+	items := make([]VarianceItem, 0)
+	matches := make([]MatchItem, 0)
+	builder := newVarianceBuilder(sources, ruleset, func(item VarianceItem) error {
+		items = append(items, item)
+		return nil
+	}, func(match MatchItem) error {
+		matches = append(matches, match)
+		return nil
+	})
+	for _, record := range sorted {
+		if err := builder.add(record); err != nil {
+			t.Fatalf("add record: %v", err)
+		}
+	}
+	leftoverItems, leftoverMatches, err := builder.finish()
+	if err != nil {
+		t.Fatalf("finish variances: %v", err)
+	}
+	items = append(items, leftoverItems...)
+	matches = append(matches, leftoverMatches...)
+	sortVarianceItems(items)
+	sortMatchItems(matches)
+	return items, matches, builder.varianceSummary()
+}
+
+func TestComputeVariancesTolerance(t *testing.T) {
+	records := []NormalizedRecord{
+		{Source: "ledger", Key: "transaction_id=1", ID: "1", AmountMinor: 10000, Currency: "USD"},
+		{Source: "bank", Key: "transaction_id=1", ID: "1", AmountMinor: 10002, Currency: "USD"},
+		{Source: "ledger", Key: "transaction_id=2", ID: "2", AmountMinor: 100000, Currency: "USD"},
+		{Source: "bank", Key: "transaction_id=2", ID: "2", AmountMinor: 100400, Currency: "USD"},
+		{Source: "ledger", Key: "transaction_id=3", ID: "3", AmountMinor: 5000, Currency: "USD"},
+		{Source: "bank", Key: "transaction_id=3", ID: "3", AmountMinor: 5100, Currency: "USD"},
+	}
+	ruleset := &Ruleset{ToleranceMinorUnits: 2, TolerancePercent: 0.5}
+
+	items, matches, summary := computeVariances(t, records, []string{"bank", "ledger"}, ruleset)
+
+	if len(items) != 1 || items[0].Type != "amount_mismatch" || items[0].Key != "transaction_id=3" {
+		t.Fatalf("only the difference beyond tolerance should be a variance: %+v", items)
+	}
+	if items[0].ToleranceMinorUnits == nil || *items[0].ToleranceMinorUnits != 25 {
+		t.Fatalf("amount_mismatch should carry the applied tolerance: %+v", items[0])
+	}
+	if len(matches) != 2 {
+		t.Fatalf("match count mismatch: got %d want 2", len(matches))
+	}
+	for i, want := range []struct {
+		tolerance  int64
+		confidence float64
+	}{{50, 0.996}, {502, 0.9203}} {
+		if matches[i].Type != "within_tolerance" {
+			t.Fatalf("match %d type mismatch: got %s want within_tolerance", i, matches[i].Type)
+		}
+		if matches[i].ToleranceMinorUnits == nil || *matches[i].ToleranceMinorUnits != want.tolerance {
+			t.Fatalf("match %d tolerance mismatch: got %v want %d", i, matches[i].ToleranceMinorUnits, want.tolerance)
+		}
+		if matches[i].Confidence != want.confidence {
+			t.Fatalf("match %d confidence mismatch: got %v want %v", i, matches[i].Confidence, want.confidence)
+		}
+	}
+	if summary.Total != 1 || summary.CountsByType["amount_mismatch"] != 1 {
+		t.Fatalf("within_tolerance results should not count as variances: %+v", summary)
+	}
+	if totals := summary.ByCurrency["USD"]; totals.Count != 1 || totals.TotalAbsoluteVarianceMinorUnits != 100 {
+		t.Fatalf("within_tolerance results should not add exposure: %+v", summary.ByCurrency)
+	}
+
+	strict, _, _ := computeVariances(t, records[:2], []string{"bank", "ledger"}, &Ruleset{})
+	if len(strict) != 1 || strict[0].ToleranceMinorUnits == nil || *strict[0].ToleranceMinorUnits != 0 {
+		t.Fatalf("a zero tolerance should still be recorded: %+v", strict)
+	}
+}
+
+func TestComputeVariancesFuzzyDateWindow(t *testing.T) {
+	records := []NormalizedRecord{
+		{Source: "ledger", Key: "ref=A1", ID: "A1", Account: "acct-1", AmountMinor: 2500, Currency: "USD", Timestamp: "2024-01-01T10:00:00Z"},
+		{Source: "bank", Key: "ref=B7", ID: "B7", Account: "acct-1", AmountMinor: 2500, Currency: "USD", Timestamp: "2024-01-02T08:00:00Z"},
+		{Source: "ledger", Key: "ref=A2", ID: "A2", Account: "acct-1", AmountMinor: 900, Currency: "USD", Timestamp: "2024-01-01T10:00:00Z"},
+		{Source: "bank", Key: "ref=B8", ID: "B8", Account: "acct-1", AmountMinor: 900, Currency: "USD", Timestamp: "2024-01-09T10:00:00Z"},
+	}
+	window := 2
+	ruleset := &Ruleset{DateWindowDays: &window}
+
+	items, matches, summary := computeVariances(t, records, []string{"bank", "ledger"}, ruleset)
+
+	if _, ok := summary.CountsByType["fuzzy_match"]; ok || summary.Total != 2 {
+		t.Fatalf("fuzzy pairs should be matches only: %v", summary.CountsByType)
+	}
+	if summary.CountsByType["missing_record"] != 2 || len(items) != 2 {
+		t.Fatalf("missing_record count mismatch: got %d want 2", summary.CountsByType["missing_record"])
+	}
+	if len(matches) != 1 || matches[0].Type != "fuzzy_match" {
+		t.Fatalf("fuzzy_match not emitted: %+v", matches)
+	}
+	fuzzy := matches[0]
+	if fuzzy.Key != "ref=A1" || len(fuzzy.MatchedKeys) != 2 || fuzzy.MatchedKeys[1] != "ref=B7" {
+		t.Fatalf("unexpected fuzzy pairing: %+v", fuzzy)
+	}
+	if fuzzy.DateDeltaDays == nil || *fuzzy.DateDeltaDays != 1 {
+		t.Fatalf("date delta mismatch: %v", fuzzy.DateDeltaDays)
+	}
+	if fuzzy.Confidence != 0.8667 {
+		t.Fatalf("fuzzy confidence should fall with the date gap: %+v", fuzzy)
+	}
+}
+
+func TestComputeVariancesFuzzySameDayAndClosestFirst(t *testing.T) {
+	records := []NormalizedRecord{
+		{Source: "ledger", Key: "ref=A1", ID: "A1", AmountMinor: 2500, Currency: "USD", Timestamp: "2024-01-01T10:00:00Z"},
+		{Source: "bank", Key: "ref=B1", ID: "B1", AmountMinor: 2500, Currency: "USD", Timestamp: "2024-01-03T08:00:00Z"},
+		{Source: "ledger", Key: "ref=C1", ID: "C1", AmountMinor: 2500, Currency: "USD", Timestamp: "2024-01-03T17:00:00Z"},
+	}
+
+	window := 2
+	_, matches, _ := computeVariances(t, records, []string{"bank", "ledger"}, &Ruleset{DateWindowDays: &window})
+	if len(matches) != 1 || matches[0].MatchedKeys[0] != "ref=B1" || matches[0].MatchedKeys[1] != "ref=C1" {
+		t.Fatalf("the closest-dated pair should win over key order: %+v", matches)
+	}
+	if matches[0].Confidence != 0.9 {
+		t.Fatalf("a same-day fuzzy match should keep the full fuzzy confidence: %v", matches[0].Confidence)
+	}
+
+	converted := int64(2300)
+	records[1].OriginalAmountMinor, records[1].OriginalCurrency = &converted, "EUR"
+	_, matches, _ = computeVariances(t, records, []string{"bank", "ledger"}, &Ruleset{DateWindowDays: &window})
+	if len(matches) != 1 || len(matches[0].AmountsBySource[0].OriginalAmounts) != 1 ||
+		matches[0].AmountsBySource[0].OriginalAmounts[0].AmountMinor != 2300 {
+		t.Fatalf("fuzzy match should carry original amounts: %+v", matches)
+	}
+
+	sameDay := 0
+	_, matches, _ = computeVariances(t, records, []string{"bank", "ledger"}, &Ruleset{DateWindowDays: &sameDay})
+	if len(matches) != 1 || matches[0].DateDeltaDays == nil || *matches[0].DateDeltaDays != 0 {
+		t.Fatalf("a window of 0 should pair same-day records: %+v", matches)
+	}
+
+	_, matches, _ = computeVariances(t, records, []string{"bank", "ledger"}, &Ruleset{})
+	if len(matches) != 0 {
+		t.Fatalf("fuzzy matching should be off without a window: %+v", matches)
+	}
+}
+
+func TestComputeVariancesFieldsOnFuzzyAndAggregateMatches(t *testing.T) {
+	records := []NormalizedRecord{
+		{Source: "bank", Key: "ref=B1", ID: "B1", AmountMinor: 2500, Currency: "USD", Timestamp: "2024-01-03T08:00:00Z", Fields: map[string]string{"memo": "rent"}},
+		{Source: "ledger", Key: "ref=L1", ID: "L1", AmountMinor: 2500, Currency: "USD", Timestamp: "2024-01-03T17:00:00Z", Fields: map[string]string{"memo": "rent march"}},
+		{Source: "bank", Key: "ref=P1", ID: "P1", AmountMinor: 900, Currency: "USD", Timestamp: "2024-01-05T00:00:00Z", Fields: map[string]string{"memo": "payout"}},
+		{Source: "ledger", Key: "ref=C1", ID: "C1", AmountMinor: 400, Currency: "USD", Timestamp: "2024-01-05T00:00:00Z", Fields: map[string]string{"memo": "payout"}},
+		{Source: "ledger", Key: "ref=C2", ID: "C2", AmountMinor: 500, Currency: "USD", Timestamp: "2024-01-05T00:00:00Z", Fields: map[string]string{"memo": "refund"}},
+	}
+	window := 1
+	ruleset := &Ruleset{CompareKeys: []string{"memo"}, DateWindowDays: &window, Grouping: &GroupingRule{WindowDays: 1}}
+	items, matches, _ := computeVariances(t, records, []string{"bank", "ledger"}, ruleset)
+	if len(matches) != 2 {
+		t.Fatalf("expected a fuzzy and an aggregate match: %+v", matches)
+	}
+	mismatches := make(map[string]VarianceItem)
+	for _, item := range items {
+		if item.Type == "field_mismatch" {
+			mismatches[item.Key] = item
+		}
+	}
+	fuzzy, ok := mismatches["ref=B1"]
+	if !ok || len(fuzzy.FieldDifferences) != 1 || len(fuzzy.FieldDifferences[0].ValuesBySource) != 2 ||
+		fuzzy.FieldDifferences[0].ValuesBySource[1].Value != "rent march" || len(fuzzy.MatchedKeys) != 2 {
+		t.Fatalf("fuzzy match should compare fields across its keys: %+v", mismatches)
+	}
+	aggregate, ok := mismatches["ref=P1"]
+	if !ok || len(aggregate.FieldDifferences[0].ValuesBySource) != 3 || aggregate.FieldDifferences[0].ValuesBySource[2].Value != "refund" {
+		t.Fatalf("aggregate match should compare fields across its keys: %+v", mismatches)
+	}
+}
+
+func TestComputeVariancesAggregateMatch(t *testing.T) {
+	records := []NormalizedRecord{
+		{Source: "bank", Key: "id=po_1", ID: "po_1", AmountMinor: 4500, Currency: "USD", Timestamp: "2024-01-03T00:00:00Z", Reference: "po_1"},
+		{Source: "ledger", Key: "id=ch_1", ID: "ch_1", AmountMinor: 1000, Currency: "USD", Timestamp: "2024-01-01T00:00:00Z", Reference: "po_1"},
+		{Source: "ledger", Key: "id=ch_2", ID: "ch_2", AmountMinor: 1500, Currency: "USD", Timestamp: "2024-01-01T00:00:00Z", Reference: "po_1"},
+		{Source: "ledger", Key: "id=ch_3", ID: "ch_3", AmountMinor: 2000, Currency: "USD", Timestamp: "2024-01-02T00:00:00Z", Reference: "po_1"},
+		{Source: "ledger", Key: "id=ch_4", ID: "ch_4", AmountMinor: 700, Currency: "USD", Timestamp: "2024-01-02T00:00:00Z", Reference: "po_2"},
+	}
+	ruleset := &Ruleset{Grouping: &GroupingRule{ReferenceField: "payout_id", WindowDays: 3}}
+
+	items, matches, summary := computeVariances(t, records, []string{"bank", "ledger"}, ruleset)
+
+	if len(matches) != 1 {
+		t.Fatalf("match count mismatch: got %d want 1", len(matches))
+	}
+	match := matches[0]
+	if match.Type != "one_to_many" || match.Key != "id=po_1" || match.Confidence != 0.9 {
+		t.Fatalf("unexpected match: %+v", match)
+	}
+	if len(match.RecordsBySource) != 2 || len(match.RecordsBySource[1].RecordIDs) != 3 {
+		t.Fatalf("unexpected record ids: %+v", match.RecordsBySource)
+	}
+	if summary.Total != 1 || len(items) != 1 || items[0].Key != "id=ch_4" {
+		t.Fatalf("expected only id=ch_4 to remain unmatched, got %+v", items)
+	}
+}
+
+func TestComputeVariancesAggregateWindowSubset(t *testing.T) {
+	records := []NormalizedRecord{
+		{Source: "bank", Key: "id=po_1", ID: "po_1", AmountMinor: 2500, Currency: "USD", Timestamp: "2024-01-03T00:00:00Z"},
+		{Source: "ledger", Key: "id=ch_1", ID: "ch_1", AmountMinor: 1000, Currency: "USD", Timestamp: "2024-01-01T00:00:00Z"},
+		{Source: "ledger", Key: "id=ch_2", ID: "ch_2", AmountMinor: 333, Currency: "USD", Timestamp: "2024-01-02T00:00:00Z"},
+		{Source: "ledger", Key: "id=ch_3", ID: "ch_3", AmountMinor: 1500, Currency: "USD", Timestamp: "2024-01-02T00:00:00Z"},
+	}
+	ruleset := &Ruleset{Grouping: &GroupingRule{WindowDays: 3}}
+
+	for _, sources := range [][]string{{"bank", "ledger"}, {"ledger", "bank"}} {
+		items, matches, _ := computeVariances(t, records, sources, ruleset)
+		if len(matches) != 1 {
+			t.Fatalf("an unrelated charge in the window should not block the payout: %+v", matches)
+		}
+		match := matches[0]
+		if match.Type != "one_to_many" || match.AnchorSource != "bank" || match.Key != "id=po_1" {
+			t.Fatalf("match should be labelled from the payout's side whatever the source order: %+v", match)
+		}
+		if match.Confidence != 0.8667 {
+			t.Fatalf("a partial aggregate should score below a full one: %v", match.Confidence)
+		}
+		if strings.Join(match.MatchedKeys, ",") != "id=ch_1,id=ch_3,id=po_1" {
+			t.Fatalf("unexpected matched keys: %v", match.MatchedKeys)
+		}
+		if len(items) != 1 || items[0].Key != "id=ch_2" || items[0].Type != "missing_record" {
+			t.Fatalf("expected only the unrelated charge to remain: %+v", items)
+		}
+	}
+}
+
+func TestComputeVariancesDuplicatePolicies(t *testing.T) {
+	records := []NormalizedRecord{
+		{Source: "bank", Key: "id=1", ID: "b-1", AmountMinor: 500, Currency: "USD"},
+		{Source: "ledger", Key: "id=1", ID: "l-1", AmountMinor: 500, Currency: "USD", ordinal: 0},
+		{Source: "ledger", Key: "id=1", ID: "l-2", AmountMinor: 500, Currency: "USD", ordinal: 1},
+	}
+	sources := []string{"bank", "ledger"}
+
+	_, _, summary := computeVariances(t, records, sources, &Ruleset{DuplicatePolicy: "sum"})
+	if summary.CountsByType["amount_mismatch"] != 1 || summary.CountsByType["duplicate_record"] != 0 {
+		t.Fatalf("sum policy counts mismatch: %v", summary.CountsByType)
+	}
+
+	items, matches, summary := computeVariances(t, records, sources, &Ruleset{DuplicatePolicy: "flag"})
+	if summary.Total != 1 || items[0].Type != "duplicate_record" {
+		t.Fatalf("flag policy should only report the duplicate, got %+v", items)
+	}
+	if ids := items[0].DuplicateRecords[0].RecordIDs; len(ids) != 2 || ids[0] != "l-1" || ids[1] != "l-2" {
+		t.Fatalf("duplicate record ids mismatch: %v", ids)
+	}
+	if len(matches) != 1 || matches[0].RecordsBySource[1].RecordIDs[0] != "l-1" {
+		t.Fatalf("flag policy should compare the first record, got %+v", matches)
+	}
+
+	_, _, summary = computeVariances(t, records, sources, &Ruleset{DuplicatePolicy: "first_wins"})
+	if summary.Total != 0 {
+		t.Fatalf("first_wins policy should report nothing, got %v", summary.CountsByType)
+	}
+}
+
+func TestComputeVariancesCurrencyMismatch(t *testing.T) {
+	records := []NormalizedRecord{
+		{Source: "bank", Key: "id=1", ID: "1", AmountMinor: 1000, Currency: "EUR"},
+		{Source: "ledger", Key: "id=1", ID: "1", AmountMinor: 1000, Currency: "USD"},
+		{Source: "bank", Key: "id=2", ID: "2", AmountMinor: 2000, Currency: "USD"},
+		{Source: "ledger", Key: "id=2", ID: "2", AmountMinor: 2050, Currency: "USD"},
+		{Source: "ledger", Key: "id=3", ID: "3", AmountMinor: -300, Currency: "USD"},
+	}
+
+	items, matches, summary := computeVariances(t, records, []string{"bank", "ledger"}, &Ruleset{})
+
+	if summary.CountsByType["currency_mismatch"] != 1 || items[0].Type != "currency_mismatch" {
+		t.Fatalf("expected a currency_mismatch for id=1, got %+v", items)
+	}
+	if len(items[0].AmountsBySource) != 2 || items[0].AmountsBySource[0].Currency != "EUR" {
+		t.Fatalf("currency_mismatch should list per-source currencies: %+v", items[0].AmountsBySource)
+	}
+	if len(matches) != 0 {
+		t.Fatalf("mixed currencies must not match: %+v", matches)
+	}
+	if got := summary.ByCurrency["USD"]; got.Count != 3 || got.TotalAbsoluteVarianceMinorUnits != 1350 {
+		t.Fatalf("USD totals mismatch: %+v", got)
+	}
+	if got := summary.ByCurrency["EUR"]; got.Count != 1 || got.TotalAbsoluteVarianceMinorUnits != 1000 {
+		t.Fatalf("EUR totals mismatch: %+v", got)
+	}
+}
+
+func TestCurrencyTotalsCountOnlyExceptions(t *testing.T) {
+	records := []NormalizedRecord{
+		{Source: "ledger", Key: "ref=A1", ID: "A1", Account: "acct-1", AmountMinor: 2500, Currency: "USD", Timestamp: "2024-01-01T10:00:00Z"},
+		{Source: "bank", Key: "ref=B7", ID: "B7", Account: "acct-1", AmountMinor: 2500, Currency: "USD", Timestamp: "2024-01-02T08:00:00Z"},
+		{Source: "bank", Key: "ref=C1", ID: "C1-a", AmountMinor: 700, Currency: "USD", ordinal: 0},
+		{Source: "bank", Key: "ref=C1", ID: "C1-b", AmountMinor: 700, Currency: "USD", ordinal: 1},
+		{Source: "ledger", Key: "ref=C1", ID: "C1", AmountMinor: 700, Currency: "USD"},
+		{Source: "ledger", Key: "ref=D1", ID: "D1", AmountMinor: 400, Currency: "USD"},
+	}
+	window := 2
+	ruleset := &Ruleset{DateWindowDays: &window, DuplicatePolicy: "flag"}
+
+	_, _, summary := computeVariances(t, records, []string{"bank", "ledger"}, ruleset)
+
+	if summary.Total != 2 || summary.CountsByType["duplicate_record"] != 1 || summary.CountsByType["missing_record"] != 1 {
+		t.Fatalf("unexpected counts: %v", summary.CountsByType)
+	}
+	if len(summary.ByCurrency) != 1 {
+		t.Fatalf("only currencies with exceptions should be listed: %+v", summary.ByCurrency)
+	}
+	if got := summary.ByCurrency["USD"]; got.Count != 1 || got.TotalAbsoluteVarianceMinorUnits != 400 {
+		t.Fatalf("USD totals should cover only the missing record: %+v", got)
+	}
+}
+
+func TestRecordSorterCascadesMerges(t *testing.T) {
+	sorter := newRecordSorter(3)
+	sorter.fanIn = 4
+	defer sorter.close()
+	for index := 0; index < 100; index++ {
+		key := fmt.Sprintf("id=%03d", (index*37)%100)
+		if err := sorter.add(NormalizedRecord{Source: "bank", Key: key, ID: key, ordinal: index}); err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+	keys := make([]string, 0, 100)
+	if err := sorter.each(func(record NormalizedRecord) error {
+		keys = append(keys, record.Key)
+		return nil
+	}); err != nil {
+		t.Fatalf("each: %v", err)
+	}
+	if len(sorter.runs) > sorter.fanIn {
+		t.Fatalf("final merge opened %d runs", len(sorter.runs))
+	}
+	entries, err := os.ReadDir(sorter.dir)
+	if err != nil || len(entries) != len(sorter.runs) {
+		t.Fatalf("merged runs should be removed: %d files for %d runs, %v", len(entries), len(sorter.runs), err)
+	}
+	if len(keys) != 100 || !sort.StringsAreSorted(keys) {
+		t.Fatalf("records not merged in order: %v", keys)
+	}
+}
+
+func TestVarianceBuilderRetainsOnlyIncompleteGroups(t *testing.T) {
+	sorter := newRecordSorter(64)
+	defer sorter.close()
+	for index := 0; index < 5000; index++ {
+		key := fmt.Sprintf("id=%05d", index)
+		amount := int64(100 + index%7)
+		for _, source := range []string{"bank", "ledger"} {
+			// Every 1000th key is missing from the bank and must be kept.
+			if source == "bank" && index%1000 == 0 {
+				continue
+			}
+			if err := sorter.add(NormalizedRecord{Source: source, Key: key, ID: key, AmountMinor: amount, Currency: "USD"}); err != nil {
+				t.Fatalf("add: %v", err)
+			}
+		}
+	}
+
+	emitted, matched := 0, 0
+	builder := newVarianceBuilder([]string{"bank", "ledger"}, &Ruleset{}, func(VarianceItem) error {
+		emitted++
+		return nil
+	}, func(MatchItem) error {
+		matched++
+		return nil
+	})
+	maxLeftovers, maxRun := 0, 0
+	if err := sorter.each(func(record NormalizedRecord) error {
+		if err := builder.add(record); err != nil {
+			return err
+		}
+		if len(builder.leftovers) > maxLeftovers {
+			maxLeftovers = len(builder.leftovers)
+		}
+		if len(builder.run) > maxRun {
+			maxRun = len(builder.run)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("each: %v", err)
+	}
+	if maxLeftovers > 5 || maxRun > 1 {
+		t.Fatalf("builder retained too much state: %d leftover groups, run of %d", maxLeftovers, maxRun)
+	}
+	if matched != 4994 || emitted != 0 {
+		t.Fatalf("complete groups should be emitted while streaming: %d matches, %d items", matched, emitted)
+	}
+
+	items, matches, err := builder.finish()
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+	if len(items) != 5 || len(matches) != 0 || matched != 4995 {
+		t.Fatalf("unexpected final results: %d items, %d matches, %d emitted matches", len(items), len(matches), matched)
+	}
+	for _, item := range items {
+		if item.Type != "missing_record" {
+			t.Fatalf("unexpected item: %+v", item)
+		}
+	}
+	if summary := builder.matchSummary(); summary.Total != 4995 {
+		t.Fatalf("match total mismatch: got %d want 4995", summary.Total)
+	}
+}
+
+func TestParseAmountMinorUnits(t *testing.T) {
+	cases := []struct {
+		value    string
+		currency string
+		want     int64
+	}{
+		{"1500", "JPY", 1500},
+		{"1500.5", "JPY", 1500},
+		{"1501.5", "JPY", 1502},
+		{"12.345", "KWD", 12345},
+		{"-0.0125", "BHD", -12},
+		{"19.99", "USD", 1999},
+		{"19.99", "", 1999},
+	}
+	for _, tc := range cases {
+		got, _, warning := parseAmount(tc.value, roundingRule{Mode: "bankers", Increment: 1}, minorUnitsFor(tc.currency, nil))
+		if warning != "" {
+			t.Fatalf("parseAmount(%q, %s) warned: %s", tc.value, tc.currency, warning)
+		}
+		if got != tc.want {
+			t.Fatalf("parseAmount(%q, %s): got %d want %d", tc.value, tc.currency, got, tc.want)
+		}
+	}
+
+	if got := minorUnitsFor("jpy", map[string]int{"JPY": 2}); got != 2 {
+		t.Fatalf("ruleset override ignored: got %d want 2", got)
+	}
+}
+
+func TestNormalizeAmountText(t *testing.T) {
+	us := &AmountFormat{DecimalSeparator: ".", GroupingSeparator: ",", NegativeStyles: []string{"parentheses", "trailing_minus", "cr_negative"}, StripSymbols: true}
+	eu := &AmountFormat{DecimalSeparator: ",", GroupingSeparator: "."}
+	spaced := &AmountFormat{DecimalSeparator: ",", GroupingSeparator: " "}
+
+	cases := []struct {
+		value  string
+		format *AmountFormat
+		want   string
+	}{
+		{"1,234.56", us, "1234.56"},
+		{"(45.00)", us, "-45.00"},
+		{"$12.00", us, "12.00"},
+		{"($1,200.00)", us, "-1200.00"},
+		{"12.00 CR", us, "-12.00"},
+		{"12.00 DR", us, "12.00"},
+		{"12.00-", us, "-12.00"},
+		{"USD 7.50", us, "7.50"},
+		{"1.234,56", eu, "1234.56"},
+		{"-1.234,56", eu, "-1234.56"},
+		{"1 234,56", spaced, "1234.56"},
+		{"12.00", nil, "12.00"},
+	}
+	for _, tc := range cases {
+		got, warning := normalizeAmountText(tc.value, tc.format)
+		if warning != "" {
+			t.Fatalf("normalizeAmountText(%q) warned: %s", tc.value, warning)
+		}
+		if got != tc.want {
+			t.Fatalf("normalizeAmountText(%q): got %q want %q", tc.value, got, tc.want)
+		}
+	}
+
+	if _, warning := normalizeAmountText("1,234.56", eu); warning == "" {
+		t.Fatalf("expected a warning for a misplaced decimal separator")
+	}
+}
+
+func TestParseAmountRoundingModes(t *testing.T) {
+	cases := []struct {
+		value     string
+		mode      string
+		increment int64
+		want      int64
+	}{
+		{"1.005", "bankers", 1, 100},
+		{"1.015", "bankers", 1, 102},
+		{"1.005", "half_up", 1, 101},
+		{"-1.005", "nearest", 1, -101},
+		{"1.001", "up", 1, 101},
+		{"-1.009", "up", 1, -100},
+		{"1.009", "down", 1, 100},
+		{"-1.001", "down", 1, -101},
+		{"-1.009", "toward_zero", 1, -100},
+		{"-1.001", "away_from_zero", 1, -101},
+		{"12.37", "nearest", 5, 1235},
+		{"12.38", "nearest", 5, 1240},
+		{"12.375", "nearest", 5, 1240},
+		{"-12.32", "down", 5, -1235},
+		{"12.30", "up", 5, 1230},
+	}
+	for _, tc := range cases {
+		got, _, warning := parseAmount(tc.value, roundingRule{Mode: tc.mode, Increment: tc.increment}, 2)
+		if warning != "" {
+			t.Fatalf("parseAmount(%q, %s) warned: %s", tc.value, tc.mode, warning)
+		}
+		if got != tc.want {
+			t.Fatalf("parseAmount(%q, %s/%d): got %d want %d", tc.value, tc.mode, tc.increment, got, tc.want)
+		}
+	}
+
+	if _, rounded, _ := parseAmount("12.30", roundingRule{Mode: "nearest", Increment: 5}, 2); rounded {
+		t.Fatalf("exact multiples should not be reported as rounded")
+	}
+	if _, rounded, _ := parseAmount("12.31", roundingRule{Mode: "nearest", Increment: 5}, 2); !rounded {
+		t.Fatalf("adjusted amounts should be reported as rounded")
+	}
+}
+
+func TestConvertAmountRoundingIncrement(t *testing.T) {
+	table, err := loadFXRates(writeTempFile(t, "rates.csv", "date,from,to,rate\n2024-03-01,EUR,USD,1.1\n"))
+	if err != nil {
+		t.Fatalf("load rates: %v", err)
+	}
+	cases := []struct {
+		amount int64
+		rule   roundingRule
+		want   int64
+		exact  string
+	}{
+		// 50.01 EUR at 1.1 is 55.011 USD.
+		{5001, roundingRule{Mode: "bankers", Increment: 1}, 5501, "55.011"},
+		{5001, roundingRule{Mode: "bankers", Increment: 5}, 5500, "55.011"},
+		{5001, roundingRule{Mode: "up", Increment: 5}, 5505, "55.011"},
+		// 50.00 EUR converts to exactly 55.00 USD, so nothing is rounded.
+		{5000, roundingRule{Mode: "bankers", Increment: 5}, 5500, ""},
+	}
+	for _, tc := range cases {
+		got, _, exact, err := table.convertAmount(tc.amount, "EUR", 2, "USD", 2, "2024-03-01", tc.rule)
+		if err != nil {
+			t.Fatalf("convert %d: %v", tc.amount, err)
+		}
+		if got != tc.want || exact != tc.exact {
+			t.Fatalf("convert %d with %+v: got %d (exact %q), want %d (exact %q)", tc.amount, tc.rule, got, exact, tc.want, tc.exact)
+		}
+	}
+}
+
+func TestLoadRulesetContractStrict(t *testing.T) {
+	valid := `{
+  "match_keys": ["id"],
+  "compare_keys": ["amount"],
+  "tolerance_minor_units": 0,
+  "rounding": {"mode": "down", "increment_minor_units": 5},
+  "timezone": "Europe/Zurich",
+  "schema_version": "1.0.0"
+}`
+	ruleset, err := loadRuleset(writeTempFile(t, "ruleset.json", valid))
+	if err != nil {
+		t.Fatalf("load contract ruleset: %v", err)
+	}
+	if ruleset.KeyFields[0] != "id" || ruleset.AmountField != "amount" || ruleset.Rounding.IncrementMinorUnits != 5 {
+		t.Fatalf("contract ruleset not translated: %+v", ruleset)
+	}
+	for compareKeys, want := range map[string]string{
+		`["gross_amount", "currency"]`:           "gross_amount",
+		`["currency", "$.payment.amount"]`:       "payment.amount",
+		`["fee_amount", "net_amount"]`:           "amount",
+		`["memo"]`:                               "amount",
+		`["Amount", "fee_amount", "net_amount"]`: "Amount",
+	} {
+		ruleset, err := loadRuleset(writeTempFile(t, "ruleset.json", strings.Replace(valid, `["amount"]`, compareKeys, 1)))
+		if err != nil || ruleset.AmountField != want {
+			t.Fatalf("compare_keys %s: amount field %q, %v want %q", compareKeys, ruleset.AmountField, err, want)
+		}
+	}
+
+	invalid := map[string]string{
+		"unknown field":    strings.Replace(valid, `"timezone"`, `"key_fields": ["id"], "timezone"`, 1),
+		"unknown rounding": strings.Replace(valid, `"increment_minor_units": 5`, `"increment_minor_units": 5, "scale": 2`, 1),
+		"missing field":    strings.Replace(valid, `"tolerance_minor_units": 0,`, "", 1),
+		"bad mode":         strings.Replace(valid, `"down"`, `"bankers"`, 1),
+		"bad increment":    strings.Replace(valid, `"increment_minor_units": 5`, `"increment_minor_units": 0`, 1),
+		"fractional":       strings.Replace(valid, `"tolerance_minor_units": 0`, `"tolerance_minor_units": 0.5`, 1),
+	}
+	for name, body := range invalid {
+		if _, err := loadRuleset(writeTempFile(t, "ruleset.json", body)); err == nil {
+			t.Fatalf("%s: expected contract ruleset to be rejected", name)
+		}
+	}
+}
+
+func writeTempFile(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestComputeVariancesFieldMismatch(t *testing.T) {
+	records := []NormalizedRecord{
+		{Source: "bank", Key: "id=1", ID: "1", AmountMinor: 100, Currency: "USD", Fields: map[string]string{"account": "acct-1", "status": "settled"}},
+		{Source: "ledger", Key: "id=1", ID: "1", AmountMinor: 100, Currency: "USD", Fields: map[string]string{"account": "acct-1", "status": "pending"}},
+		{Source: "bank", Key: "id=2", ID: "2", AmountMinor: 200, Currency: "USD", Fields: map[string]string{"account": "acct-1", "status": "settled"}},
+		{Source: "ledger", Key: "id=2", ID: "2", AmountMinor: 200, Currency: "USD", Fields: map[string]string{"account": "acct-1", "status": "settled"}},
+	}
+	ruleset := &Ruleset{KeyFields: []string{"id"}, AmountField: "amount", CurrencyField: "currency", CompareKeys: []string{"amount", "account", "status"}}
+
+	items, matches, summary := computeVariances(t, records, []string{"bank", "ledger"}, ruleset)
+
+	if summary.CountsByType["field_mismatch"] != 1 || len(items) != 1 {
+		t.Fatalf("expected one field_mismatch, got %+v", items)
+	}
+	differences := items[0].FieldDifferences
+	if len(differences) != 1 || differences[0].Field != "status" {
+		t.Fatalf("unexpected field differences: %+v", differences)
+	}
+	if values := differences[0].ValuesBySource; values[0].Value != "settled" || values[1].Value != "pending" {
+		t.Fatalf("unexpected values by source: %+v", values)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("amounts still match on both keys, got %d matches", len(matches))
+	}
+
+	summed := []NormalizedRecord{
+		{Source: "bank", Key: "id=3", ID: "3a", AmountMinor: 100, Currency: "USD", Fields: map[string]string{"status": "settled"}},
+		{Source: "bank", Key: "id=3", ID: "3b", AmountMinor: 50, Currency: "USD", Fields: map[string]string{"status": "reversed"}},
+		{Source: "ledger", Key: "id=3", ID: "3", AmountMinor: 150, Currency: "USD", Fields: map[string]string{"status": "settled"}},
+	}
+	ruleset.DuplicatePolicy = "sum"
+	items, _, _ = computeVariances(t, summed, []string{"bank", "ledger"}, ruleset)
+	if len(items) != 1 || items[0].Type != "field_mismatch" {
+		t.Fatalf("conflicting values within a source should be reported, got %+v", items)
+	}
+	values := items[0].FieldDifferences[0].ValuesBySource
+	if len(values) != 3 || values[0] != (SourceValue{Source: "bank", Value: "reversed"}) ||
+		values[1] != (SourceValue{Source: "bank", Value: "settled"}) || values[2] != (SourceValue{Source: "ledger", Value: "settled"}) {
+		t.Fatalf("unexpected values by source: %+v", values)
+	}
+}
+
+func TestLoadRulesetYAML(t *testing.T) {
+	jsonRuleset, err := loadRuleset(filepath.Join("fixtures", "basic", "ruleset.json"))
+	if err != nil {
+		t.Fatalf("load json ruleset: %v", err)
+	}
+	yamlRuleset, err := loadRuleset(writeTempFile(t, "ruleset.yaml", `# Same ruleset as fixtures/basic/ruleset.json
+schema_version: "1.0.0"
+sources: [source_a, source_b]
+key_fields:
+  - transaction_id
+amount_field: amount
+currency_field: currency
+timestamp_field: timestamp
+account_field: account
+`))
+	if err != nil {
+		t.Fatalf("load yaml ruleset: %v", err)
+	}
+	jsonHash, _ := canonicalRulesetHash(jsonRuleset)
+	yamlHash, _ := canonicalRulesetHash(yamlRuleset)
+	if jsonHash != yamlHash {
+		t.Fatalf("canonical hash differs between json and yaml: %s vs %s", jsonHash, yamlHash)
+	}
+
+	cases := map[string]struct {
+		name    string
+		content string
+		want    string
+	}{
+		"yaml type error":             {"ruleset.yml", "key_fields: [id]\namount_field: amount\ntolerance_minor_units: lots\n", "line 3, column 24"},
+		"yaml unknown contract field": {"ruleset.yaml", "match_keys: [id]\ncompare_keys: []\ntolerance_minor_units: 0\nrounding:\n  mode: up\n  increment_minor_units: 1\n  scale: 2\ntimezone: UTC\nschema_version: \"1.0.0\"\n", "line 7, column 3"},
+		"yaml syntax error":           {"ruleset.yaml", "key_fields: [id\namount_field: amount\n", "line "},
+		"json syntax error":           {"ruleset.json", "{\n  \"key_fields\": [\"id\"],\n  \"amount_field\" \"amount\"\n}\n", "line 3, column 18"},
+		"json type error":             {"ruleset.json", "{\n  \"key_fields\": \"id\"\n}\n", "line 2, column 17"},
+	}
+	for label, tc := range cases {
+		_, err := loadRuleset(writeTempFile(t, tc.name, tc.content))
+		if err == nil {
+			t.Fatalf("%s: expected an error", label)
+		}
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: error %q does not mention %q", label, err.Error(), tc.want)
+		}
+	}
+}
+
+func TestReadJSONLRejectsMalformedLines(t *testing.T) {
+	input := "{\"id\": \"1\", \"amount\": 10.5}\n\n{\"id\": \"2\", \"amount\": \r\n[1, 2]\n{\"id\": \"3\", \"amount\": \"7\"}"
+	records := make([]map[string]string, 0)
+	rejected := make([]inputRow, 0)
+	err := readJSONL(strings.NewReader(input), func(row inputRow) error {
+		if row.reject != "" {
+			rejected = append(rejected, row)
+		} else {
+			records = append(records, row.fields)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("read jsonl: %v", err)
+	}
+	if len(records) != 2 || records[0]["amount"] != "10.5" || records[1]["id"] != "3" {
+		t.Fatalf("unexpected records: %v", records)
+	}
+	if len(rejected) != 2 || rejected[0].line != 3 || !strings.HasPrefix(rejected[0].reject, "malformed json") {
+		t.Fatalf("unexpected rejected rows: %+v", rejected)
+	}
+	if rejected[1].line != 4 || rejected[1].reject != "expected a json object" || rejected[1].raw() != "[1, 2]" {
+		t.Fatalf("unexpected rejected row: %+v", rejected[1])
+	}
+}
+
+func TestNestedFieldPaths(t *testing.T) {
+	payload := `[{"id": "evt_1", "payment": {"amount": {"value": 12.5, "currency": "EUR"}}, "metadata": {"order_id": "ord_9"}, "lines": [{"sku": "A"}, {"sku": "B"}]}]`
+	records := make([]map[string]string, 0)
+	err := readJSON(strings.NewReader(payload), "", func(row inputRow) error {
+		records = append(records, row.fields)
+		return nil
+	}, func(string) {})
+	if err != nil {
+		t.Fatalf("read json: %v", err)
+	}
+	record := records[0]
+	if record["payment.amount.value"] != "12.5" || record["metadata.order_id"] != "ord_9" || record["lines[1].sku"] != "B" {
+		t.Fatalf("nested fields not flattened: %v", record)
+	}
+	if record["metadata"] != `{"order_id":"ord_9"}` {
+		t.Fatalf("top-level object should still render as json: %q", record["metadata"])
+	}
+
+	for ref, want := range map[string]string{
+		"payment.amount.value":            "payment.amount.value",
+		"$.metadata.order_id":             "metadata.order_id",
+		"$['payment']['amount']['value']": "payment.amount.value",
+		`$.lines[0]["sku"]`:               "lines[0].sku",
+		"lines[0].sku":                    "lines[0].sku",
+		"transaction_id":                  "transaction_id",
+		"Amount [USD]":                    "Amount [USD]",
+		"$Amount":                         "$Amount",
+	} {
+		got, err := canonicalFieldPath(ref)
+		if err != nil || got != want {
+			t.Fatalf("canonicalFieldPath(%q): got %q, %v want %q", ref, got, err, want)
+		}
+	}
+	for _, ref := range []string{"$.lines[0", "$.lines[*].sku", "$"} {
+		if _, err := canonicalFieldPath(ref); err == nil {
+			t.Fatalf("canonicalFieldPath(%q) should fail", ref)
+		}
+	}
+
+	mappingPath := writeTempFile(t, "mapping.json", `{"sources": {"stripe": {"id": "$.id", "amount": "$.payment.amount.value", "currency": "payment.amount.currency"}}}`)
+	mapping, err := loadMapping(&mappingPath)
+	if err != nil {
+		t.Fatalf("load mapping: %v", err)
+	}
+	ruleset := &Ruleset{KeyFields: []string{"metadata.order_id"}, AmountField: "amount", CurrencyField: "currency"}
+	mapped := mapRecord(record, "stripe", ruleset, mapping)
+	if mapped["amount"] != "12.5" || mapped["currency"] != "EUR" {
+		t.Fatalf("mapping did not follow nested paths: %v", mapped)
+	}
+	if key, _ := buildKey(mapped, ruleset.KeyFields); key != "metadata.order_id=ord_9" {
+		t.Fatalf("unexpected key: %q", key)
+	}
+}
+
+func TestBracketedCSVHeaders(t *testing.T) {
+	mappingPath := writeTempFile(t, "mapping.json", `{"sources": {"bank": {"id": "Ref", "amount": "Amount [USD]", "currency": "Betrag[EUR]"}}}`)
+	mapping, err := loadMapping(&mappingPath)
+	if err != nil {
+		t.Fatalf("bracketed headers should load as literal names: %v", err)
+	}
+	rows := make([]map[string]string, 0)
+	if err := readCSV(strings.NewReader("Ref,Amount [USD],Betrag[EUR]\nr1,12.50,EUR\n"), nil, func(row inputRow) error {
+		rows = append(rows, row.fields)
+		return nil
+	}); err != nil {
+		t.Fatalf("read csv: %v", err)
+	}
+	ruleset := &Ruleset{AmountField: "amount", CurrencyField: "currency"}
+	mapped := mapRecord(rows[0], "bank", ruleset, mapping)
+	if mapped["id"] != "r1" || mapped["amount"] != "12.50" || mapped["currency"] != "EUR" {
+		t.Fatalf("bracketed headers not mapped: %v", mapped)
+	}
+}
+
+func TestReadJSONRecordsPath(t *testing.T) {
+	read := func(payload string, recordsPath string) ([]map[string]string, []string, error) {
+		records := make([]map[string]string, 0)
+		warnings := make([]string, 0)
+		err := readJSON(strings.NewReader(payload), recordsPath, func(row inputRow) error {
+			records = append(records, row.fields)
+			return nil
+		}, func(message string) {
+			warnings = append(warnings, message)
+		})
+		return records, warnings, err
+	}
+
+	payload := `{"meta": {"page": 1}, "results": {"count": 2, "transactions": [{"id": "1"}, 7, {"id": "2"}, null]}, "tail": [1]}`
+	records, warnings, err := read(payload, "results.transactions")
+	if err != nil {
+		t.Fatalf("read records_path: %v", err)
+	}
+	if len(records) != 2 || records[1]["id"] != "2" {
+		t.Fatalf("unexpected records: %v", records)
+	}
+	if len(warnings) != 1 || warnings[0] != "skipped 2 non-object items" {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+
+	records, _, err = read(`{"pages": [{"items": []}, {"items": [{"id": "9"}]}]}`, "$.pages[1].items")
+	if err != nil || len(records) != 1 || records[0]["id"] != "9" {
+		t.Fatalf("indexed records_path: %v, %v", records, err)
+	}
+
+	if _, _, err := read(payload, "data"); err == nil || !strings.Contains(err.Error(), "records_path data not found") {
+		t.Fatalf("missing records_path should fail, got %v", err)
+	}
+	if _, _, err := read(payload, "results.count"); err == nil || !strings.Contains(err.Error(), "is not an array") {
+		t.Fatalf("non-array records_path should fail, got %v", err)
+	}
+	if _, _, err := read(`{"data": []} {}`, "data"); err == nil {
+		t.Fatalf("trailing data should fail")
+	}
+
+	records, warnings, err = read(`{"data": [{"id": "1"}]}`, "")
+	if err != nil || len(records) != 0 || len(warnings) != 1 {
+		t.Fatalf("object without records should warn, got %v, %v, %v", records, warnings, err)
+	}
+}
+
+func TestPlainDecimal(t *testing.T) {
+	for text, want := range map[string]string{
+		"90071992547409.93": "90071992547409.93",
+		"1.5e-1":            "0.15",
+		"-2.5E3":            "-2500",
+		"125e-5":            "0.00125",
+		"0e5":               "0",
+		"1.2345e2":          "123.45",
+	} {
+		if got := plainDecimal(text); got != want {
+			t.Fatalf("plainDecimal(%q): got %q want %q", text, got, want)
+		}
+	}
+}
+
+func TestReadCSVDialect(t *testing.T) {
+	read := func(input string, dialect *CSVDialect) []map[string]string {
+		t.Helper()
+		if err := validateCSVDialect(dialect); err != nil {
+			t.Fatalf("validate dialect: %v", err)
+		}
+		records := make([]map[string]string, 0)
+		err := readCSV(strings.NewReader(input), dialect, func(row inputRow) error {
+			records = append(records, row.fields)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("read csv: %v", err)
+		}
+		return records
+	}
+
+	records := read("\xef\xbb\xbfid,amount\n1,10.00\n", nil)
+	if records[0]["id"] != "1" {
+		t.Fatalf("byte order mark not stripped: %v", records[0])
+	}
+
+	bank := "Kontoauszug;Export\r\nZeitraum: 03/2024\r\n\r\nBuchung;Betrag;Empf\xe4nger;Notiz\r\n# storniert\r\n7;1.234,56;Caf\xe9 M\xfcller;\x80 \x93fee\x94\r\n"
+	records = read(bank, &CSVDialect{Delimiter: ";", Comment: "#", SkipRows: 2, HeaderRow: 0, Encoding: "windows-1252"})
+	if len(records) != 1 || records[0]["Betrag"] != "1.234,56" || records[0]["Empfänger"] != "Café Müller" || records[0]["Notiz"] != "€ “fee”" {
+		t.Fatalf("unexpected windows-1252 records: %v", records)
+	}
+	records = read("preamble line\n\nid|amount\n1|5\n", &CSVDialect{Delimiter: "|", HeaderRow: 1, Encoding: "latin1"})
+	if len(records) != 1 || records[0]["amount"] != "5" {
+		t.Fatalf("header_row not applied: %v", records)
+	}
+
+	records = read("1,10.00,USD\n2,20.00,EUR\n", &CSVDialect{NoHeader: true})
+	if len(records) != 2 || records[1]["1"] != "2" || records[1]["3"] != "EUR" {
+		t.Fatalf("positional columns not applied: %v", records)
+	}
+
+	rejected := make([]string, 0)
+	if err := readCSV(strings.NewReader("id;amount\r\n# note\r\n\r\n1;2\"0\r\n2;30\r\n3\r\n"), &CSVDialect{Delimiter: ";", Comment: "#"}, func(row inputRow) error {
+		if row.reject != "" {
+			rejected = append(rejected, fmt.Sprintf("%d:%s", row.line, row.raw()))
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("a stray quote should not end the read: %v", err)
+	}
+	if strings.Join(rejected, "|") != `4:1;2"0|6:3` {
+		t.Fatalf("unexpected rejected rows: %q", rejected)
+	}
+	records = read("id,memo\n1,12\" pipe\n2,\"say \"hi\"\"\n", &CSVDialect{LazyQuotes: true})
+	if len(records) != 2 || records[0]["memo"] != `12" pipe` || records[1]["memo"] != `say "hi"` {
+		t.Fatalf("lazy_quotes not applied: %v", records)
+	}
+
+	for _, dialect := range []*CSVDialect{
+		{Delimiter: ";;"},
+		{Encoding: "utf-16"},
+		{NoHeader: true, HeaderRow: 2},
+		{SkipRows: -1},
+	} {
+		if err := validateCSVDialect(dialect); err == nil {
+			t.Fatalf("dialect %+v should be rejected", dialect)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FXTable holds conversion rates keyed by currency pair, each sorted by date.
+type FXTable struct {
+	rates map[string][]fxRate
+}
+
+type fxRate struct {
+	date string
+	rate *big.Rat
+	text string
+}
+
+type fxRateRow struct {
+	Date string `json:"date"`
+	From string `json:"from"`
+	To   string `json:"to"`
+	Rate string `json:"rate"`
+}
+
+func loadFXRates(path string) (*FXTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read fx rates: %w", err)
+	}
+
+	var rows []fxRateRow
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return nil, fmt.Errorf("parse fx rates json: %w", err)
+		}
+	} else {
+		err := readCSV(bytes.NewReader(data), nil, func(row inputRow) error {
+			if row.reject != "" {
+				return fmt.Errorf("line %d: %s", row.line, row.reject)
+			}
+			record := row.fields
+			rows = append(rows, fxRateRow{Date: record["date"], From: record["from"], To: record["to"], Rate: record["rate"]})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("parse fx rates csv: %w", err)
+		}
+	}
+
+	table := &FXTable{rates: map[string][]fxRate{}}
+	for index, row := range rows {
+		from := strings.ToUpper(strings.TrimSpace(row.From))
+		to := strings.ToUpper(strings.TrimSpace(row.To))
+		if from == "" || to == "" {
+			return nil, fmt.Errorf("fx rate %d: from and to are required", index+1)
+		}
+		if _, err := time.Parse("2006-01-02", strings.TrimSpace(row.Date)); err != nil {
+			return nil, fmt.Errorf("fx rate %d: invalid date %q", index+1, row.Date)
+		}
+		rate, ok := new(big.Rat).SetString(strings.TrimSpace(row.Rate))
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("fx rate %d: invalid rate %q", index+1, row.Rate)
+		}
+		pair := from + "/" + to
+		table.rates[pair] = append(table.rates[pair], fxRate{date: strings.TrimSpace(row.Date), rate: rate, text: strings.TrimSpace(row.Rate)})
+	}
+	for pair := range table.rates {
+		entries := table.rates[pair]
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].date < entries[j].date
+		})
+	}
+	return table, nil
+}
+
+// lookup returns the most recent rate on or before date for converting from
+// one currency to another, falling back to the inverse pair. An empty date
+// selects the latest rate available.
+func (table *FXTable) lookup(from string, to string, date string) (*big.Rat, string, bool) {
+	if rate, text, ok := latestRate(table.rates[from+"/"+to], date); ok {
+		return rate, text, true
+	}
+	if rate, text, ok := latestRate(table.rates[to+"/"+from], date); ok {
+		return new(big.Rat).Inv(rate), "1/" + text, true
+	}
+	return nil, "", false
+}
+
+func latestRate(entries []fxRate, date string) (*big.Rat, string, bool) {
+	var found *fxRate
+	for index := range entries {
+		if date != "" && entries[index].date > date {
+			break
+		}
+		found = &entries[index]
+	}
+	if found == nil {
+		return nil, "", false
+	}
+	return found.rate, found.text, true
+}
+
+// convertAmount converts minor units into the reporting currency using the
+// rate effective on date, the record's normalized calendar date, rescaling between the two currencies'
+// minor unit exponents and rounding with the run's rounding rule. When
+// rounding changed the converted value, the exact value is returned as a
+// decimal in the reporting currency; otherwise it is empty.
+func (table *FXTable) convertAmount(amount int64, from string, fromExponent int, to string, toExponent int, date string, rounding roundingRule) (int64, string, string, error) {
+	rate, text, ok := table.lookup(strings.ToUpper(from), strings.ToUpper(to), date)
+	if !ok {
+		return 0, "", "", fmt.Errorf("no fx rate for %s to %s on %s", from, to, date)
+	}
+	converted := new(big.Rat).Mul(new(big.Rat).SetInt64(amount), rate)
+	if toExponent > fromExponent {
+		converted.Mul(converted, new(big.Rat).SetInt(pow10(toExponent-fromExponent)))
+	} else if fromExponent > toExponent {
+		converted.Quo(converted, new(big.Rat).SetInt(pow10(fromExponent-toExponent)))
+	}
+	result, ok := roundRat(converted, rounding)
+	if !ok {
+		return 0, "", "", fmt.Errorf("converted amount out of range for %s to %s", from, to)
+	}
+	exact := ""
+	if converted.Cmp(new(big.Rat).SetInt64(result)) != 0 {
+		exact = ratDecimal(new(big.Rat).Quo(converted, new(big.Rat).SetInt(pow10(toExponent))))
+	}
+	return result, text, exact, nil
+}
+
+// ratDecimal renders a rational with a terminating decimal expansion, such as
+// a converted amount, without trailing zeros.
+func ratDecimal(value *big.Rat) string {
+	text := value.FloatString(18)
+	text = strings.TrimRight(text, "0")
+	return strings.TrimSuffix(text, ".")
+}
@@ -1,3 +1,8 @@
 module github.com/shardie-github/settler-oss/tools/settler-engine
 
 go 1.21
+
+require (
+	github.com/klauspost/compress v1.17.11
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// defaultMaxRecordsInMemory bounds how many normalized records are buffered
+// before the sorter spills a sorted run to disk.
+const defaultMaxRecordsInMemory = 250000
+
+// maxMergeFanIn bounds how many run files are open at once. With more runs
+// than this, groups of runs are merged into longer runs until the rest fit.
+const maxMergeFanIn = 64
+
+// recordSorter orders normalized records by key, source, amount, ID and input
+// order. Records are buffered up to a limit; beyond it each full buffer is
+// sorted and written to a temporary run file, and the runs are merged when
+// the records are read back. Both paths produce the same order.
+type recordSorter struct {
+	limit  int
+	fanIn  int
+	buffer []NormalizedRecord
+	dir    string
+	runs   []string
+	files  int
+}
+
+// spilledRecord carries the unexported input ordinal through a run file.
+type spilledRecord struct {
+	Record  NormalizedRecord `json:"record"`
+	Ordinal int              `json:"ordinal"`
+}
+
+func newRecordSorter(limit int) *recordSorter {
+	if limit <= 0 {
+		limit = defaultMaxRecordsInMemory
+	}
+	return &recordSorter{limit: limit, fanIn: maxMergeFanIn}
+}
+
+func recordLess(left *NormalizedRecord, right *NormalizedRecord) bool {
+	if left.Key != right.Key {
+		return left.Key < right.Key
+	}
+	if left.Source != right.Source {
+		return left.Source < right.Source
+	}
+	if left.AmountMinor != right.AmountMinor {
+		return left.AmountMinor < right.AmountMinor
+	}
+	if left.ID != right.ID {
+		return left.ID < right.ID
+	}
+	return left.ordinal < right.ordinal
+}
+
+func (sorter *recordSorter) add(record NormalizedRecord) error {
+	sorter.buffer = append(sorter.buffer, record)
+	if len(sorter.buffer) >= sorter.limit {
+		return sorter.spill()
+	}
+	return nil
+}
+
+func (sorter *recordSorter) sortBuffer() {
+	sort.Slice(sorter.buffer, func(i, j int) bool {
+		return recordLess(&sorter.buffer[i], &sorter.buffer[j])
+	})
+}
+
+func (sorter *recordSorter) spill() error {
+	if len(sorter.buffer) == 0 {
+		return nil
+	}
+	if sorter.dir == "" {
+		dir, err := os.MkdirTemp("", "settler-engine-sort-")
+		if err != nil {
+			return fmt.Errorf("create sort dir: %w", err)
+		}
+		sorter.dir = dir
+	}
+	sorter.sortBuffer()
+
+	path, err := sorter.writeRun(func(write func(NormalizedRecord) error) error {
+		for _, record := range sorter.buffer {
+			if err := write(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sorter.runs = append(sorter.runs, path)
+	sorter.buffer = sorter.buffer[:0]
+	return nil
+}
+
+// writeRun writes the records produced by fill to a new run file.
+func (sorter *recordSorter) writeRun(fill func(func(NormalizedRecord) error) error) (string, error) {
+	path := filepath.Join(sorter.dir, fmt.Sprintf("run-%06d.jsonl", sorter.files))
+	sorter.files++
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("create sort run: %w", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	err = fill(func(record NormalizedRecord) error {
+		if err := encoder.Encode(spilledRecord{Record: record, Ordinal: record.ordinal}); err != nil {
+			return fmt.Errorf("write sort run: %w", err)
+		}
+		return nil
+	})
+	if err == nil {
+		if err = writer.Flush(); err != nil {
+			err = fmt.Errorf("write sort run: %w", err)
+		}
+	}
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("write sort run: %w", closeErr)
+	}
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// each calls fn with every record in sorted order.
+func (sorter *recordSorter) each(fn func(NormalizedRecord) error) error {
+	if len(sorter.runs) == 0 {
+		sorter.sortBuffer()
+		for _, record := range sorter.buffer {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := sorter.spill(); err != nil {
+		return err
+	}
+	for len(sorter.runs) > sorter.fanIn {
+		if err := sorter.cascade(); err != nil {
+			return err
+		}
+	}
+	return mergeRuns(sorter.runs, fn)
+}
+
+// cascade merges each group of fanIn runs into one longer run, so a later
+// pass opens fewer files.
+func (sorter *recordSorter) cascade() error {
+	runs := make([]string, 0, len(sorter.runs)/sorter.fanIn+1)
+	for start := 0; start < len(sorter.runs); start += sorter.fanIn {
+		group := sorter.runs[start:min(start+sorter.fanIn, len(sorter.runs))]
+		if len(group) == 1 {
+			runs = append(runs, group[0])
+			continue
+		}
+		path, err := sorter.writeRun(func(write func(NormalizedRecord) error) error {
+			return mergeRuns(group, write)
+		})
+		if err != nil {
+			return err
+		}
+		for _, merged := range group {
+			if err := os.Remove(merged); err != nil {
+				return fmt.Errorf("remove sort run: %w", err)
+			}
+		}
+		runs = append(runs, path)
+	}
+	sorter.runs = runs
+	return nil
+}
+
+// mergeRuns calls fn with the records of the run files in sorted order.
+func mergeRuns(paths []string, fn func(NormalizedRecord) error) error {
+	files := make([]*os.File, 0, len(paths))
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	merge := make(runMerge, 0, len(paths))
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("open sort run: %w", err)
+		}
+		files = append(files, file)
+		run := &sortRun{decoder: json.NewDecoder(bufio.NewReader(file))}
+		ok, err := run.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			merge = append(merge, run)
+		}
+	}
+	heap.Init(&merge)
+	for merge.Len() > 0 {
+		run := merge[0]
+		if err := fn(run.current); err != nil {
+			return err
+		}
+		ok, err := run.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&merge, 0)
+		} else {
+			heap.Pop(&merge)
+		}
+	}
+	return nil
+}
+
+// close removes any run files written by the sorter.
+func (sorter *recordSorter) close() error {
+	sorter.buffer = nil
+	if sorter.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(sorter.dir)
+}
+
+type sortRun struct {
+	decoder *json.Decoder
+	current NormalizedRecord
+}
+
+func (run *sortRun) advance() (bool, error) {
+	var spilled spilledRecord
+	if err := run.decoder.Decode(&spilled); err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		return false, fmt.Errorf("read sort run: %w", err)
+	}
+	run.current = spilled.Record
+	run.current.ordinal = spilled.Ordinal
+	return true, nil
+}
+
+// runMerge is a min-heap of sort runs ordered by their current record.
+type runMerge []*sortRun
+
+func (merge runMerge) Len() int { return len(merge) }
+
+func (merge runMerge) Less(i, j int) bool {
+	return recordLess(&merge[i].current, &merge[j].current)
+}
+
+func (merge runMerge) Swap(i, j int) { merge[i], merge[j] = merge[j], merge[i] }
+
+func (merge *runMerge) Push(value any) { *merge = append(*merge, value.(*sortRun)) }
+
+func (merge *runMerge) Pop() any {
+	old := *merge
+	run := old[len(old)-1]
+	*merge = old[:len(old)-1]
+	return run
+}
+
+// resultSpill holds results that are emitted in order while the record stream
+// is read, in a temporary file, until the results settled last are known.
+// mergeInto then writes both to their evidence file in one ordered pass.
+type resultSpill[T any] struct {
+	path   string
+	writer *jsonLinesWriter
+}
+
+func newResultSpill[T any](dir string, name string) (*resultSpill[T], error) {
+	path := filepath.Join(dir, name)
+	writer, err := newJSONLinesWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	return &resultSpill[T]{path: path, writer: writer}, nil
+}
+
+func (spill *resultSpill[T]) write(item T) error {
+	return spill.writer.write(item)
+}
+
+// mergeInto writes the spilled results merged with rest, which must already
+// be ordered by less, to path. Spilled results come first on ties.
+func (spill *resultSpill[T]) mergeInto(path string, rest []T, less func(*T, *T) bool) error {
+	if err := spill.writer.close(); err != nil {
+		return err
+	}
+	file, err := os.Open(spill.path)
+	if err != nil {
+		return fmt.Errorf("open result spill: %w", err)
+	}
+	defer file.Close()
+
+	writer, err := newJSONLinesWriter(path)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var item T
+		if err := decoder.Decode(&item); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			writer.close()
+			return fmt.Errorf("read result spill: %w", err)
+		}
+		for len(rest) > 0 && less(&rest[0], &item) {
+			if err := writer.write(rest[0]); err != nil {
+				writer.close()
+				return err
+			}
+			rest = rest[1:]
+		}
+		if err := writer.write(item); err != nil {
+			writer.close()
+			return err
+		}
+	}
+	for _, item := range rest {
+		if err := writer.write(item); err != nil {
+			writer.close()
+			return err
+		}
+	}
+	return writer.close()
+}
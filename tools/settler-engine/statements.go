@@ -0,0 +1,663 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statementEntry is one booked line of a bank statement. Every statement
+// format is read into these standardized fields so statements reconcile
+// against ledger exports without format-specific mappings.
+type statementEntry struct {
+	account         string
+	currency        string
+	valueDate       string
+	bookingDate     string
+	amount          string
+	debit           bool
+	reference       string
+	bankReference   string
+	counterparty    string
+	description     string
+	transactionCode string
+}
+
+// fields renders the entry as a record. Amounts are plain decimals, negative
+// for debits; dates are YYYY-MM-DD, and timestamp repeats the booking date, or
+// the value date when the statement has no booking date, so rulesets can
+// use their default timestamp field. id is the bank's own reference.
+func (entry statementEntry) fields() map[string]string {
+	amount := entry.amount
+	creditDebit := "credit"
+	if entry.debit {
+		creditDebit = "debit"
+		if strings.Trim(amount, "0.") != "" {
+			amount = "-" + amount
+		}
+	}
+	timestamp := entry.bookingDate
+	if timestamp == "" {
+		timestamp = entry.valueDate
+	}
+	return map[string]string{
+		"id":               entry.bankReference,
+		"account":          entry.account,
+		"currency":         entry.currency,
+		"amount":           amount,
+		"credit_debit":     creditDebit,
+		"value_date":       entry.valueDate,
+		"booking_date":     entry.bookingDate,
+		"timestamp":        timestamp,
+		"reference":        entry.reference,
+		"bank_reference":   entry.bankReference,
+		"counterparty":     entry.counterparty,
+		"description":      entry.description,
+		"transaction_code": entry.transactionCode,
+	}
+}
+
+var (
+	mt940TagPattern = regexp.MustCompile(`^:(\d{2}[A-Z]?):(.*)$`)
+	// Value date, optional entry date, debit/credit mark, optional funds
+	// code, amount, transaction type, and references.
+	mt940LinePattern   = regexp.MustCompile(`^(\d{6})(\d{4})?(RC|RD|C|D)([A-Z])?(\d[\d,]*)([A-Z][A-Z0-9]{3})(.*)$`)
+	mt940FieldPattern  = regexp.MustCompile(`\?(\d{2})`)
+	mt940NamePattern   = regexp.MustCompile(`/NAME/([^/]*)`)
+	mt940CurrencyStart = len("C240301")
+)
+
+// readMT940 reads SWIFT MT940 statements. Each :61: statement line becomes a
+// record, described by the :86: field that follows it.
+func readMT940(reader io.Reader, fn func(inputRow) error) error {
+	buffered := bufio.NewReader(reader)
+	account, currency := "", ""
+	var tag, value string
+	tagLine := 0
+	var pending *inputRow
+	var entry statementEntry
+
+	flush := func() error {
+		if pending == nil {
+			return nil
+		}
+		row := *pending
+		pending = nil
+		if row.reject == "" {
+			row.fields = entry.fields()
+		}
+		return fn(row)
+	}
+	handle := func() error {
+		switch {
+		case tag == "":
+			return nil
+		case tag == "25":
+			account = strings.TrimSpace(value)
+		case tag == "60F" || tag == "60M":
+			if len(value) >= mt940CurrencyStart+3 {
+				currency = value[mt940CurrencyStart : mt940CurrencyStart+3]
+			}
+		case tag == "61":
+			if err := flush(); err != nil {
+				return err
+			}
+			raw := ":61:" + value
+			pending = &inputRow{line: tagLine, raw: func() string { return raw }}
+			parsed, err := parseMT940Line(value)
+			if err != nil {
+				pending.reject = err.Error()
+			}
+			parsed.account, parsed.currency = account, currency
+			entry = parsed
+			return nil
+		case tag == "86" && pending != nil:
+			entry.counterparty, entry.description = parseMT940Information(value, entry.description)
+		}
+		return flush()
+	}
+
+	for line := 1; ; line++ {
+		text, err := buffered.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("read mt940: %w", err)
+		}
+		text = strings.TrimRight(text, "\r\n")
+		trimmed := strings.TrimSpace(text)
+		switch {
+		case strings.HasPrefix(trimmed, "{") || trimmed == "-}" || trimmed == "-":
+			// Message envelope and trailer lines end the current field.
+			if handleErr := handle(); handleErr != nil {
+				return handleErr
+			}
+			tag, value = "", ""
+		case mt940TagPattern.MatchString(text):
+			if handleErr := handle(); handleErr != nil {
+				return handleErr
+			}
+			match := mt940TagPattern.FindStringSubmatch(text)
+			tag, value, tagLine = match[1], match[2], line
+		case tag != "" && trimmed != "":
+			value += "\n" + text
+		}
+		if errors.Is(err, io.EOF) {
+			if handleErr := handle(); handleErr != nil {
+				return handleErr
+			}
+			return flush()
+		}
+	}
+}
+
+func parseMT940Line(value string) (statementEntry, error) {
+	first, supplementary, _ := strings.Cut(value, "\n")
+	match := mt940LinePattern.FindStringSubmatch(strings.TrimSpace(first))
+	if match == nil {
+		return statementEntry{}, fmt.Errorf("malformed :61: statement line %q", first)
+	}
+	valueDate, err := time.Parse("060102", match[1])
+	if err != nil {
+		return statementEntry{}, fmt.Errorf("invalid value date %q", match[1])
+	}
+	entry := statementEntry{
+		valueDate:       valueDate.Format("2006-01-02"),
+		amount:          strings.TrimSuffix(strings.Replace(match[5], ",", ".", 1), "."),
+		debit:           match[3] == "D" || match[3] == "RC",
+		transactionCode: match[6],
+		description:     strings.TrimSpace(supplementary),
+	}
+	if match[2] != "" {
+		bookingDate, err := nearestYearDate(match[2], valueDate)
+		if err != nil {
+			return statementEntry{}, err
+		}
+		entry.bookingDate = bookingDate.Format("2006-01-02")
+	}
+	reference, bankReference, _ := strings.Cut(match[7], "//")
+	if reference = strings.TrimSpace(reference); reference != "NONREF" {
+		entry.reference = reference
+	}
+	entry.bankReference = strings.TrimSpace(bankReference)
+	return entry, nil
+}
+
+// nearestYearDate resolves an MMDD entry date to the year that puts it
+// closest to the value date, since statements can cross a year end.
+func nearestYearDate(monthDay string, near time.Time) (time.Time, error) {
+	var best time.Time
+	for _, year := range []int{near.Year() - 1, near.Year(), near.Year() + 1} {
+		candidate, err := time.Parse("20060102", fmt.Sprintf("%04d%s", year, monthDay))
+		if err != nil {
+			// February 29 only exists in some of the candidate years.
+			continue
+		}
+		if best.IsZero() || absDuration(candidate.Sub(near)) < absDuration(best.Sub(near)) {
+			best = candidate
+		}
+	}
+	if best.IsZero() {
+		return time.Time{}, fmt.Errorf("invalid entry date %q", monthDay)
+	}
+	return best, nil
+}
+
+func absDuration(value time.Duration) time.Duration {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+// parseMT940Information extracts the counterparty and description from a
+// :86: field. Structured ?NN subfields (?20-?29 and ?60-?63 purpose, ?32-?33
+// name) and /NAME/ codes are recognized; anything else is kept as free text.
+func parseMT940Information(value string, supplementary string) (string, string) {
+	text := strings.ReplaceAll(value, "\n", "")
+	counterparty, purpose := "", ""
+	if locations := mt940FieldPattern.FindAllStringSubmatchIndex(text, -1); len(locations) > 0 {
+		for index, location := range locations {
+			end := len(text)
+			if index+1 < len(locations) {
+				end = locations[index+1][0]
+			}
+			content := text[location[1]:end]
+			code, _ := strconv.Atoi(text[location[2]:location[3]])
+			switch {
+			case code == 32 || code == 33:
+				counterparty += content
+			case (code >= 20 && code <= 29) || (code >= 60 && code <= 63):
+				purpose += content
+			}
+		}
+	} else {
+		purpose = text
+		if match := mt940NamePattern.FindStringSubmatch(text); match != nil {
+			counterparty = match[1]
+		}
+	}
+	description := strings.TrimSpace(purpose)
+	if supplementary != "" {
+		description = strings.TrimSpace(supplementary + " " + description)
+	}
+	return strings.TrimSpace(counterparty), description
+}
+
+type camtAccount struct {
+	IBAN     string `xml:"Id>IBAN"`
+	Other    string `xml:"Id>Othr>Id"`
+	Currency string `xml:"Ccy"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+func (date camtDate) day() string {
+	value := date.Date
+	if value == "" {
+		value = date.DateTime
+	}
+	if len(value) > len("2006-01-02") {
+		value = value[:len("2006-01-02")]
+	}
+	return value
+}
+
+type camtParty struct {
+	Name      string `xml:"Nm"`
+	PartyName string `xml:"Pty>Nm"`
+}
+
+func (party camtParty) name() string {
+	if party.Name != "" {
+		return party.Name
+	}
+	return party.PartyName
+}
+
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+type camtTransaction struct {
+	Amount            camtAmount `xml:"Amt"`
+	TransactionAmount camtAmount `xml:"AmtDtls>TxAmt>Amt"`
+	CreditDebit       string     `xml:"CdtDbtInd"`
+	ServicerReference string     `xml:"Refs>AcctSvcrRef"`
+	EndToEndID        string     `xml:"Refs>EndToEndId"`
+	InstructionID     string     `xml:"Refs>InstrId"`
+	Debtor            camtParty  `xml:"RltdPties>Dbtr"`
+	Creditor          camtParty  `xml:"RltdPties>Cdtr"`
+	Unstructured      []string   `xml:"RmtInf>Ustrd"`
+	AdditionalInfo    string     `xml:"AddtlTxInf"`
+}
+
+// amount returns the transaction's own amount, from Amt in newer message
+// versions or AmtDtls/TxAmt in older ones.
+func (transaction camtTransaction) amount() camtAmount {
+	if strings.TrimSpace(transaction.Amount.Value) != "" {
+		return transaction.Amount
+	}
+	return transaction.TransactionAmount
+}
+
+func (transaction camtTransaction) reference() string {
+	if transaction.EndToEndID != "" && transaction.EndToEndID != "NOTPROVIDED" {
+		return transaction.EndToEndID
+	}
+	return transaction.InstructionID
+}
+
+// counterparty is whoever is on the other side of the booking.
+func (transaction camtTransaction) counterparty(debit bool) string {
+	if debit {
+		return transaction.Creditor.name()
+	}
+	return transaction.Debtor.name()
+}
+
+func (transaction camtTransaction) description() string {
+	if len(transaction.Unstructured) > 0 {
+		return strings.Join(transaction.Unstructured, " ")
+	}
+	return transaction.AdditionalInfo
+}
+
+type camtEntry struct {
+	EntryReference    string            `xml:"NtryRef"`
+	Amount            camtAmount        `xml:"Amt"`
+	CreditDebit       string            `xml:"CdtDbtInd"`
+	BookingDate       camtDate          `xml:"BookgDt"`
+	ValueDate         camtDate          `xml:"ValDt"`
+	ServicerReference string            `xml:"AcctSvcrRef"`
+	DomainCode        string            `xml:"BkTxCd>Domn>Fmly>SubFmlyCd"`
+	ProprietaryCode   string            `xml:"BkTxCd>Prtry>Cd"`
+	AdditionalInfo    string            `xml:"AddtlNtryInf"`
+	Transactions      []camtTransaction `xml:"NtryDtls>TxDtls"`
+}
+
+// readCAMT053 streams the entries of ISO 20022 camt.053 statements. Element
+// names are matched without namespaces so every message version is read. A
+// batch entry whose transactions each carry an amount becomes one record per
+// transaction; otherwise the entry is one record listing the references and
+// counterparties of all its transactions. Every row keeps the entry's XML as
+// its raw text.
+func readCAMT053(reader io.Reader, fn func(inputRow) error) error {
+	recorder := &rawRecorder{reader: reader}
+	decoder := xml.NewDecoder(recorder)
+	var account camtAccount
+	for {
+		recorder.discard(decoder.InputOffset())
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("parse camt.053: %w", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "Stmt":
+			account = camtAccount{}
+		case "Acct":
+			if err := decoder.DecodeElement(&account, &start); err != nil {
+				return fmt.Errorf("parse camt.053 account: %w", err)
+			}
+		case "Ntry":
+			line, _ := decoder.InputPos()
+			var ntry camtEntry
+			if err := decoder.DecodeElement(&ntry, &start); err != nil {
+				return fmt.Errorf("parse camt.053 entry: %w", err)
+			}
+			raw := strings.TrimSpace(recorder.text(offset, decoder.InputOffset()))
+			for _, row := range camtRows(ntry, account, line, raw) {
+				if err := fn(row); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func camtRows(ntry camtEntry, account camtAccount, line int, raw string) []inputRow {
+	entry := statementEntry{
+		account:         account.IBAN,
+		currency:        ntry.Amount.Currency,
+		valueDate:       ntry.ValueDate.day(),
+		bookingDate:     ntry.BookingDate.day(),
+		amount:          strings.TrimSpace(ntry.Amount.Value),
+		debit:           ntry.CreditDebit == "DBIT",
+		reference:       ntry.EntryReference,
+		bankReference:   ntry.ServicerReference,
+		description:     ntry.AdditionalInfo,
+		transactionCode: ntry.ProprietaryCode,
+	}
+	if entry.account == "" {
+		entry.account = account.Other
+	}
+	if entry.currency == "" {
+		entry.currency = account.Currency
+	}
+	if entry.transactionCode == "" {
+		entry.transactionCode = ntry.DomainCode
+	}
+
+	split := len(ntry.Transactions) > 1
+	for _, transaction := range ntry.Transactions {
+		if strings.TrimSpace(transaction.amount().Value) == "" {
+			split = false
+		}
+	}
+	if split {
+		rows := make([]inputRow, 0, len(ntry.Transactions))
+		for _, transaction := range ntry.Transactions {
+			item := entry
+			amount := transaction.amount()
+			item.amount = strings.TrimSpace(amount.Value)
+			if amount.Currency != "" {
+				item.currency = amount.Currency
+			}
+			creditDebit := ntry.CreditDebit
+			if transaction.CreditDebit != "" {
+				creditDebit = transaction.CreditDebit
+			}
+			item.debit = creditDebit == "DBIT"
+			if transaction.ServicerReference != "" {
+				item.bankReference = transaction.ServicerReference
+			}
+			if reference := transaction.reference(); reference != "" {
+				item.reference = reference
+			}
+			item.counterparty = transaction.counterparty(item.debit)
+			if description := transaction.description(); description != "" {
+				item.description = description
+			}
+			rows = append(rows, camtRow(item, creditDebit, line, raw))
+		}
+		return rows
+	}
+
+	references := make([]string, 0, len(ntry.Transactions))
+	counterparties := make([]string, 0, len(ntry.Transactions))
+	descriptions := make([]string, 0, len(ntry.Transactions))
+	for _, transaction := range ntry.Transactions {
+		if reference := transaction.reference(); reference != "" {
+			references = append(references, reference)
+		}
+		if counterparty := transaction.counterparty(entry.debit); counterparty != "" {
+			counterparties = append(counterparties, counterparty)
+		}
+		if description := transaction.description(); description != "" {
+			descriptions = append(descriptions, description)
+		}
+	}
+	if len(references) > 0 {
+		entry.reference = strings.Join(references, "; ")
+	}
+	entry.counterparty = strings.Join(counterparties, "; ")
+	if len(descriptions) > 0 {
+		entry.description = strings.Join(descriptions, "; ")
+	}
+	return []inputRow{camtRow(entry, ntry.CreditDebit, line, raw)}
+}
+
+func camtRow(entry statementEntry, creditDebit string, line int, raw string) inputRow {
+	entry.description = strings.Join(strings.Fields(entry.description), " ")
+	row := inputRow{line: line, raw: func() string { return raw }}
+	switch {
+	case entry.amount == "":
+		row.reject = "entry has no amount"
+	case creditDebit != "CRDT" && creditDebit != "DBIT":
+		row.reject = fmt.Sprintf("unknown credit/debit indicator %q", creditDebit)
+	default:
+		row.fields = entry.fields()
+	}
+	return row
+}
+
+// sniffCAMT053 checks that an XML document's root element is in a camt.053
+// namespace, so an .xml file of another kind is not read as a statement. It
+// looks only at the buffered start of the document.
+func sniffCAMT053(reader *bufio.Reader) error {
+	head, _ := reader.Peek(reader.Size())
+	decoder := xml.NewDecoder(bytes.NewReader(head))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return errors.New("xml document has no root element; set the source format explicitly")
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			if !strings.Contains(start.Name.Space, "camt.053") {
+				return fmt.Errorf("xml root %s in namespace %q is not a camt.053 statement; set the source format explicitly", start.Name.Local, start.Name.Space)
+			}
+			return nil
+		}
+	}
+}
+
+// readBAI2 reads BAI2 cash management files. Each 16 transaction detail
+// record, with its 88 continuations, becomes a record in the currency of the
+// enclosing 03 account, with the amount's implied decimals placed by that
+// currency's minor units.
+func readBAI2(reader io.Reader, fn func(inputRow) error) error {
+	buffered := bufio.NewReader(reader)
+	groupDate, groupCurrency := "", ""
+	account, currency := "", ""
+	var record string
+	recordLine := 0
+
+	process := func() error {
+		if record == "" {
+			return nil
+		}
+		fields := strings.Split(record, ",")
+		last := len(fields) - 1
+		fields[last] = strings.TrimSuffix(strings.TrimSpace(fields[last]), "/")
+		switch fields[0] {
+		case "02":
+			if len(fields) > 4 {
+				groupDate = bai2Date(fields[4])
+			}
+			groupCurrency = ""
+			if len(fields) > 6 {
+				groupCurrency = strings.TrimSpace(fields[6])
+			}
+		case "03":
+			account, currency = "", groupCurrency
+			if len(fields) > 1 {
+				account = strings.TrimSpace(fields[1])
+			}
+			if len(fields) > 2 && strings.TrimSpace(fields[2]) != "" {
+				currency = strings.TrimSpace(fields[2])
+			}
+			if currency == "" {
+				currency = "USD"
+			}
+		case "16":
+			raw := record
+			row := inputRow{line: recordLine, raw: func() string { return raw }}
+			entry, err := parseBAI2Detail(fields, groupDate)
+			if err != nil {
+				row.reject = err.Error()
+			} else {
+				entry.account, entry.currency = account, currency
+				entry.amount = placeDecimal(entry.amount, minorUnitsFor(currency, nil))
+				row.fields = entry.fields()
+			}
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		record = ""
+		return nil
+	}
+
+	for line := 1; ; line++ {
+		text, err := buffered.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("read bai2: %w", err)
+		}
+		text = strings.TrimRight(text, "\r\n")
+		if strings.HasPrefix(text, "88,") && record != "" {
+			continuation := strings.TrimPrefix(text, "88,")
+			if strings.HasPrefix(record, "16,") {
+				record = strings.TrimSuffix(record, "/") + " " + continuation
+			} else {
+				record = strings.TrimSuffix(record, "/") + "," + continuation
+			}
+		} else if strings.TrimSpace(text) != "" {
+			if processErr := process(); processErr != nil {
+				return processErr
+			}
+			record, recordLine = text, line
+		}
+		if errors.Is(err, io.EOF) {
+			return process()
+		}
+	}
+}
+
+// parseBAI2Detail reads a 16 record: type code, amount, funds type with its
+// availability fields, bank and customer references, and free text.
+func parseBAI2Detail(fields []string, groupDate string) (statementEntry, error) {
+	if len(fields) < 4 {
+		return statementEntry{}, errors.New("16 record is too short")
+	}
+	typeCode, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+	if err != nil {
+		return statementEntry{}, fmt.Errorf("invalid type code %q", fields[1])
+	}
+	amount := strings.TrimSpace(fields[2])
+	if !isDigits(amount) {
+		return statementEntry{}, fmt.Errorf("invalid amount %q", fields[2])
+	}
+	entry := statementEntry{
+		amount:          amount,
+		debit:           typeCode >= 400 && typeCode < 700,
+		bookingDate:     groupDate,
+		valueDate:       groupDate,
+		transactionCode: strconv.Itoa(typeCode),
+	}
+
+	next := 4
+	switch strings.TrimSpace(fields[3]) {
+	case "V":
+		if len(fields) > 4 {
+			entry.valueDate = bai2Date(fields[4])
+		}
+		next = 6
+	case "S":
+		next = 7
+	case "D":
+		if len(fields) > 4 {
+			count, err := strconv.Atoi(strings.TrimSpace(fields[4]))
+			if err != nil {
+				return statementEntry{}, fmt.Errorf("invalid distributed availability count %q", fields[4])
+			}
+			next = 5 + 2*count
+		}
+	}
+	if next < len(fields) {
+		entry.bankReference = strings.TrimSpace(fields[next])
+	}
+	if next+1 < len(fields) {
+		entry.reference = strings.TrimSpace(fields[next+1])
+	}
+	if next+2 < len(fields) {
+		entry.description = strings.TrimSpace(strings.Join(fields[next+2:], ","))
+	}
+	return entry, nil
+}
+
+func bai2Date(value string) string {
+	parsed, err := time.Parse("060102", strings.TrimSpace(value))
+	if err != nil {
+		return ""
+	}
+	return parsed.Format("2006-01-02")
+}
+
+// placeDecimal inserts the decimal point into an amount written in minor
+// units with the given exponent.
+func placeDecimal(digits string, exponent int) string {
+	if exponent == 0 {
+		return digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+	return digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
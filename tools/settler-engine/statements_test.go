@@ -0,0 +1,233 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func collectRows(t *testing.T, read func(func(inputRow) error) error) []inputRow {
+	t.Helper()
+	rows := make([]inputRow, 0)
+	if err := read(func(row inputRow) error {
+		rows = append(rows, row)
+		return nil
+	}); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return rows
+}
+
+func TestReadMT940(t *testing.T) {
+	statement := strings.Join([]string{
+		"{1:F01BANKDEFFXXXX0000000000}{2:I940BANKDEFFXXXXN}{4:",
+		":20:STMT2024-03",
+		":25:DE89370400440532013000",
+		":28C:00001/001",
+		":60F:C240229EUR1000,00",
+		":61:2403010301D125,50NTRFINV-1001//BR240301001",
+		"/OCMT/EUR125,50/",
+		":86:166?00SEPA TRANSFER?20Invoice 1001?21March?32ACME",
+		" SUPPLIES GM?33BH",
+		":61:2312310101C2000,NMSCNONREF//BR231231002",
+		":86:/ORDP//NAME/Globex Corp/REMI/Settlement",
+		":61:240302XX10,00NTRFBROKEN",
+		":62F:C240302EUR2874,50",
+		"-}",
+	}, "\r\n")
+	rows := collectRows(t, func(fn func(inputRow) error) error { return readMT940(strings.NewReader(statement), fn) })
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows, got %+v", rows)
+	}
+
+	first := rows[0].fields
+	if rows[0].line != 6 || first["amount"] != "-125.50" || first["credit_debit"] != "debit" || first["currency"] != "EUR" ||
+		first["account"] != "DE89370400440532013000" || first["value_date"] != "2024-03-01" || first["booking_date"] != "2024-03-01" ||
+		first["reference"] != "INV-1001" || first["id"] != "BR240301001" || first["counterparty"] != "ACME SUPPLIES GMBH" ||
+		first["description"] != "/OCMT/EUR125,50/ Invoice 1001March" || first["transaction_code"] != "NTRF" {
+		t.Fatalf("unexpected first entry: line %d %v", rows[0].line, first)
+	}
+	second := rows[1].fields
+	if second["amount"] != "2000" || second["credit_debit"] != "credit" || second["value_date"] != "2023-12-31" ||
+		second["booking_date"] != "2024-01-01" || second["timestamp"] != "2024-01-01" || second["reference"] != "" ||
+		second["counterparty"] != "Globex Corp" {
+		t.Fatalf("unexpected second entry: %v", second)
+	}
+	if rows[2].reject == "" || rows[2].line != 12 || rows[2].raw() != ":61:240302XX10,00NTRFBROKEN" {
+		t.Fatalf("malformed statement line should be rejected: %+v", rows[2])
+	}
+}
+
+func TestReadCAMT053(t *testing.T) {
+	document := `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+  <BkToCstmrStmt>
+    <GrpHdr><MsgId>MSG-1</MsgId></GrpHdr>
+    <Stmt>
+      <Id>STMT-1</Id>
+      <Acct><Id><IBAN>GB33BUKB20201555555555</IBAN></Id><Ccy>GBP</Ccy></Acct>
+      <Ntry>
+        <NtryRef>E1</NtryRef>
+        <Amt Ccy="GBP">250.00</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <BookgDt><Dt>2024-03-01</Dt></BookgDt>
+        <ValDt><DtTm>2024-03-02T09:30:00+00:00</DtTm></ValDt>
+        <AcctSvcrRef>SVC-001</AcctSvcrRef>
+        <NtryDtls><TxDtls>
+          <Refs><EndToEndId>PAY-77</EndToEndId></Refs>
+          <RltdPties><Dbtr><Nm>Us Ltd</Nm></Dbtr><Cdtr><Pty><Nm>Initech</Nm></Pty></Cdtr></RltdPties>
+          <RmtInf><Ustrd>Invoice</Ustrd><Ustrd>77</Ustrd></RmtInf>
+        </TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt>12.5</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <BookgDt><Dt>2024-03-03</Dt></BookgDt>
+        <AcctSvcrRef>SVC-002</AcctSvcrRef>
+        <AddtlNtryInf>Interest</AddtlNtryInf>
+        <NtryDtls><TxDtls><Refs><EndToEndId>NOTPROVIDED</EndToEndId></Refs></TxDtls></NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="GBP">300.00</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <BookgDt><Dt>2024-03-03</Dt></BookgDt>
+        <AcctSvcrRef>SVC-003</AcctSvcrRef>
+        <NtryDtls>
+          <Btch><NbOfTxs>2</NbOfTxs></Btch>
+          <TxDtls>
+            <Refs><AcctSvcrRef>SVC-003-1</AcctSvcrRef><EndToEndId>INV-1</EndToEndId></Refs>
+            <AmtDtls><TxAmt><Amt Ccy="GBP">100.00</Amt></TxAmt></AmtDtls>
+            <RltdPties><Dbtr><Nm>Globex</Nm></Dbtr></RltdPties>
+          </TxDtls>
+          <TxDtls>
+            <Refs><EndToEndId>INV-2</EndToEndId></Refs>
+            <Amt Ccy="GBP">200.00</Amt>
+            <RltdPties><Dbtr><Nm>Hooli</Nm></Dbtr></RltdPties>
+          </TxDtls>
+        </NtryDtls>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="GBP">1.00</Amt>
+        <BookgDt><Dt>2024-03-04</Dt></BookgDt>
+      </Ntry>
+    </Stmt>
+  </BkToCstmrStmt>
+</Document>`
+	rows := collectRows(t, func(fn func(inputRow) error) error { return readCAMT053(strings.NewReader(document), fn) })
+	if len(rows) != 5 {
+		t.Fatalf("expected 5 rows, got %+v", rows)
+	}
+
+	first := rows[0].fields
+	if rows[0].line != 8 || first["amount"] != "-250.00" || first["currency"] != "GBP" || first["account"] != "GB33BUKB20201555555555" ||
+		first["booking_date"] != "2024-03-01" || first["value_date"] != "2024-03-02" || first["reference"] != "PAY-77" ||
+		first["id"] != "SVC-001" || first["counterparty"] != "Initech" || first["description"] != "Invoice 77" {
+		t.Fatalf("unexpected first entry: line %d %v", rows[0].line, first)
+	}
+	second := rows[1].fields
+	if second["amount"] != "12.5" || second["credit_debit"] != "credit" || second["currency"] != "GBP" ||
+		second["reference"] != "" || second["description"] != "Interest" || second["timestamp"] != "2024-03-03" {
+		t.Fatalf("unexpected second entry: %v", second)
+	}
+	batch := []map[string]string{rows[2].fields, rows[3].fields}
+	if batch[0]["amount"] != "100.00" || batch[0]["id"] != "SVC-003-1" || batch[0]["reference"] != "INV-1" || batch[0]["counterparty"] != "Globex" ||
+		batch[1]["amount"] != "200.00" || batch[1]["id"] != "SVC-003" || batch[1]["reference"] != "INV-2" || batch[1]["counterparty"] != "Hooli" {
+		t.Fatalf("batch entry should give one row per transaction: %v", batch)
+	}
+	if rows[2].raw() != rows[3].raw() || !strings.HasPrefix(rows[2].raw(), "<Ntry>") || !strings.Contains(rows[2].raw(), "<Nm>Hooli</Nm>") {
+		t.Fatalf("batch rows should keep the entry XML: %q", rows[2].raw())
+	}
+	rejected := rows[4]
+	if rejected.reject == "" || !strings.HasPrefix(rejected.raw(), "<Ntry>") || !strings.HasSuffix(rejected.raw(), "</Ntry>") ||
+		!strings.Contains(rejected.raw(), "<Dt>2024-03-04</Dt>") {
+		t.Fatalf("entry without a credit/debit indicator should be rejected with its XML: %+v %q", rejected, rejected.raw())
+	}
+
+	other := `<?xml version="1.0"?><Invoice xmlns="urn:oasis:names:specification:ubl:schema:xsd:Invoice-2"><ID>1</ID></Invoice>`
+	err := readRecords(strings.NewReader(other), "invoice.xml", "auto", FieldMapping{}, func(inputRow) error { return nil }, func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "not a camt.053 statement") {
+		t.Fatalf("non-statement xml should not be read as camt.053: %v", err)
+	}
+	rows = collectRows(t, func(fn func(inputRow) error) error {
+		return readRecords(strings.NewReader(document), "statement.xml", "auto", FieldMapping{}, fn, func(string) {})
+	})
+	if len(rows) != 5 {
+		t.Fatalf("camt.053 xml should be detected, got %d rows", len(rows))
+	}
+}
+
+func TestReadBAI2(t *testing.T) {
+	file := strings.Join([]string{
+		"01,BANKUS,CUSTOMER,240301,0800,1,80,,2/",
+		"02,CUSTOMER,BANKUS,1,240301,0800,USD,2/",
+		"03,1234567890,USD,010,500000,,/",
+		"16,195,150000,0,BR-1,CUST-9,Wire from",
+		"88,Globex Corp",
+		"16,475,2550,V,240302,,BR-2,,Check 1042/",
+		"16,699,abc,0,BR-3,,/",
+		"03,9988,JPY,010,1000,,/",
+		"16,301,5000,D,2,0,3000,1,2000,BR-4,REF-4/",
+		"49,505550,4/",
+		"98,505550,1,6/",
+		"99,505550,1,10/",
+	}, "\n")
+	rows := collectRows(t, func(fn func(inputRow) error) error { return readBAI2(strings.NewReader(file), fn) })
+	if len(rows) != 4 {
+		t.Fatalf("expected 4 rows, got %+v", rows)
+	}
+
+	first := rows[0].fields
+	if rows[0].line != 4 || first["amount"] != "1500.00" || first["credit_debit"] != "credit" || first["account"] != "1234567890" ||
+		first["currency"] != "USD" || first["booking_date"] != "2024-03-01" || first["id"] != "BR-1" || first["reference"] != "CUST-9" ||
+		first["description"] != "Wire from Globex Corp" || first["transaction_code"] != "195" {
+		t.Fatalf("unexpected first entry: line %d %v", rows[0].line, first)
+	}
+	second := rows[1].fields
+	if second["amount"] != "-25.50" || second["credit_debit"] != "debit" || second["value_date"] != "2024-03-02" ||
+		second["booking_date"] != "2024-03-01" || second["reference"] != "" || second["description"] != "Check 1042" {
+		t.Fatalf("unexpected second entry: %v", second)
+	}
+	if rows[2].reject == "" || rows[2].line != 7 {
+		t.Fatalf("invalid amount should be rejected: %+v", rows[2])
+	}
+	if fourth := rows[3].fields; fourth["amount"] != "5000" || fourth["currency"] != "JPY" || fourth["id"] != "BR-4" || fourth["reference"] != "REF-4" {
+		t.Fatalf("unexpected fourth entry: %v", fourth)
+	}
+}
+
+func TestReadFixedWidth(t *testing.T) {
+	layout := &FixedWidthLayout{
+		SkipRows: 1,
+		Encoding: "latin-1",
+		Columns: []FixedWidthColumn{
+			{Name: "id", Start: 1, Width: 6},
+			{Name: "amount", Start: 7, Width: 10},
+			{Name: "memo", Start: 17, Width: 12},
+		},
+	}
+	if err := validateFixedWidthLayout(layout); err != nil {
+		t.Fatalf("validate layout: %v", err)
+	}
+	file := "ID    AMOUNT    MEMO\r\nA-1        12.50Caf\xe9        \r\n\r\nA-2         3.00            \r\nA-3         4.00\r\n" +
+		"A-4         5.00Tea           X\r\n"
+	rows := collectRows(t, func(fn func(inputRow) error) error { return readFixedWidth(strings.NewReader(file), layout, fn) })
+	if len(rows) != 4 {
+		t.Fatalf("expected 4 rows, got %+v", rows)
+	}
+	if first := rows[0]; first.line != 2 || first.fields["id"] != "A-1" || first.fields["amount"] != "12.50" || first.fields["memo"] != "Café" {
+		t.Fatalf("unexpected first row: line %d %v", first.line, first.fields)
+	}
+	if second := rows[1]; second.line != 4 || second.reject != "" || second.fields["amount"] != "3.00" || second.fields["memo"] != "" {
+		t.Fatalf("unexpected second row: line %d %v", second.line, second.fields)
+	}
+	if short := rows[2]; short.line != 5 || short.reject != "" || short.fields["amount"] != "4.00" || short.fields["memo"] != "" {
+		t.Fatalf("line with trimmed trailing blanks should be padded: %+v", short)
+	}
+	if long := rows[3]; long.line != 6 || long.reject == "" {
+		t.Fatalf("text past the layout should be rejected: %+v", long)
+	}
+
+	invalid := &FixedWidthLayout{Columns: []FixedWidthColumn{{Name: "id", Start: 0, Width: 4}}}
+	if err := validateFixedWidthLayout(invalid); err == nil {
+		t.Fatalf("column starting at 0 should be rejected")
+	}
+}
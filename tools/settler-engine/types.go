@@ -9,10 +9,32 @@ type EngineInput struct {
 	RulesetPath       string            `json:"ruleset_path"`
 	Currency          *string           `json:"currency"`
 	RoundingMode      string            `json:"rounding_mode"`
+	RoundingIncrement int64             `json:"rounding_increment_minor_units,omitempty"`
 	Timezone          string            `json:"timezone"`
 	OutputDir         string            `json:"output_dir"`
 	Mode              string            `json:"mode"`
 	Determinism       DeterminismConfig `json:"determinism"`
+	FXRatesPath       *string           `json:"fx_rates_path,omitempty"`
+	ReportingCurrency *string           `json:"reporting_currency,omitempty"`
+
+	MaxRecordsInMemory int `json:"max_records_in_memory,omitempty"`
+	// Strict fails the run on the first rejected row instead of recording it
+	// in evidence/rejected.jsonl.
+	Strict bool `json:"strict,omitempty"`
+	// Sources names each source with the files it is read from, in place of
+	// input_files.
+	Sources []SourceInput `json:"sources,omitempty"`
+}
+
+// SourceInput binds a source name to the files it is read from. Files may be
+// glob patterns, which expand in sorted order. Format overrides input_format
+// for these files, and Mapping stands in for the source's entry in the
+// mapping config.
+type SourceInput struct {
+	Name    string        `json:"name"`
+	Files   []string      `json:"files"`
+	Format  string        `json:"format,omitempty"`
+	Mapping *FieldMapping `json:"mapping,omitempty"`
 }
 
 type DeterminismConfig struct {
@@ -29,37 +51,193 @@ type Ruleset struct {
 	CurrencyField  string   `json:"currency_field" yaml:"currency_field"`
 	TimestampField string   `json:"timestamp_field" yaml:"timestamp_field"`
 	AccountField   string   `json:"account_field" yaml:"account_field"`
+	CompareKeys    []string `json:"compare_keys,omitempty" yaml:"compare_keys"`
+
+	Rounding *RulesetRounding `json:"rounding,omitempty" yaml:"rounding"`
+	Timezone string           `json:"timezone,omitempty" yaml:"timezone"`
+
+	ToleranceMinorUnits int64   `json:"tolerance_minor_units" yaml:"tolerance_minor_units"`
+	TolerancePercent    float64 `json:"tolerance_percent" yaml:"tolerance_percent"`
+	DateWindowDays      *int    `json:"date_window_days,omitempty" yaml:"date_window_days"`
+	DuplicatePolicy     string  `json:"duplicate_policy" yaml:"duplicate_policy"`
+
+	MinorUnits map[string]int `json:"minor_units,omitempty" yaml:"minor_units"`
+
+	Grouping *GroupingRule `json:"grouping,omitempty" yaml:"grouping"`
+}
+
+type RulesetRounding struct {
+	Mode                string `json:"mode" yaml:"mode"`
+	IncrementMinorUnits int64  `json:"increment_minor_units" yaml:"increment_minor_units"`
+}
+
+// ContractRuleset mirrors contracts/schemas/ruleset.json.
+type ContractRuleset struct {
+	MatchKeys           []string        `json:"match_keys" yaml:"match_keys"`
+	CompareKeys         []string        `json:"compare_keys" yaml:"compare_keys"`
+	ToleranceMinorUnits int64           `json:"tolerance_minor_units" yaml:"tolerance_minor_units"`
+	Rounding            RulesetRounding `json:"rounding" yaml:"rounding"`
+	Timezone            string          `json:"timezone" yaml:"timezone"`
+	SchemaVersion       string          `json:"schema_version" yaml:"schema_version"`
+}
+
+type GroupingRule struct {
+	ReferenceField string `json:"reference_field" yaml:"reference_field"`
+	WindowDays     int    `json:"window_days" yaml:"window_days"`
 }
 
 type MappingConfig struct {
-	Sources map[string]FieldMapping `json:"sources"`
+	Sources map[string]FieldMapping `json:"sources" yaml:"sources"`
 }
 
 type FieldMapping struct {
-	ID        string `json:"id"`
-	Amount    string `json:"amount"`
-	Currency  string `json:"currency"`
-	Timestamp string `json:"timestamp"`
-	Account   string `json:"account"`
+	ID        string `json:"id" yaml:"id"`
+	Amount    string `json:"amount" yaml:"amount"`
+	Currency  string `json:"currency" yaml:"currency"`
+	Timestamp string `json:"timestamp" yaml:"timestamp"`
+	Account   string `json:"account" yaml:"account"`
+
+	AmountFormat *AmountFormat `json:"amount_format,omitempty" yaml:"amount_format"`
+	// RecordsPath locates the array of records inside a JSON document, such as
+	// "data" or "results.transactions".
+	RecordsPath string `json:"records_path,omitempty" yaml:"records_path"`
+	// CSV describes the source's CSV dialect when it is not comma-separated
+	// UTF-8 with a header on the first row.
+	CSV *CSVDialect `json:"csv,omitempty" yaml:"csv"`
+	// XLSX selects the worksheet and header row of an Excel source.
+	XLSX *XLSXOptions `json:"xlsx,omitempty" yaml:"xlsx"`
+	// FixedWidth lays out the columns of a fixed_width source.
+	FixedWidth *FixedWidthLayout `json:"fixed_width,omitempty" yaml:"fixed_width"`
+	// ArchiveMembers selects the members of a zip archive to read, by glob
+	// pattern against each member's path or base name. All members are read
+	// when it is empty.
+	ArchiveMembers []string `json:"archive_members,omitempty" yaml:"archive_members"`
+}
+
+// FixedWidthLayout names the columns of a fixed-width text file by their
+// 1-based starting character and width. SkipRows drops header or preamble
+// lines, and Encoding accepts the same names as CSVDialect.
+type FixedWidthLayout struct {
+	Columns  []FixedWidthColumn `json:"columns" yaml:"columns"`
+	SkipRows int                `json:"skip_rows,omitempty" yaml:"skip_rows"`
+	Encoding string             `json:"encoding,omitempty" yaml:"encoding"`
+}
+
+type FixedWidthColumn struct {
+	Name  string `json:"name" yaml:"name"`
+	Start int    `json:"start" yaml:"start"`
+	Width int    `json:"width" yaml:"width"`
+}
+
+// XLSXOptions picks a worksheet by Sheet name or, when no name is given, by
+// zero-based SheetIndex. HeaderRow is the zero-based row holding the column
+// names; rows above it are ignored.
+type XLSXOptions struct {
+	Sheet      string `json:"sheet,omitempty" yaml:"sheet"`
+	SheetIndex int    `json:"sheet_index,omitempty" yaml:"sheet_index"`
+	HeaderRow  int    `json:"header_row,omitempty" yaml:"header_row"`
+}
+
+// CSVDialect describes how a source writes CSV files. SkipRows drops raw lines
+// before parsing begins; HeaderRow is the zero-based row, counted after them,
+// that holds the column names, and earlier rows are ignored. With NoHeader set,
+// columns are named by their 1-based position. Encoding accepts utf-8,
+// latin-1 and windows-1252. The quote character is always '"'; LazyQuotes
+// accepts stray quotes inside unquoted fields instead of rejecting the row.
+type CSVDialect struct {
+	Delimiter  string `json:"delimiter,omitempty" yaml:"delimiter"`
+	Comment    string `json:"comment,omitempty" yaml:"comment"`
+	SkipRows   int    `json:"skip_rows,omitempty" yaml:"skip_rows"`
+	HeaderRow  int    `json:"header_row,omitempty" yaml:"header_row"`
+	NoHeader   bool   `json:"no_header,omitempty" yaml:"no_header"`
+	Encoding   string `json:"encoding,omitempty" yaml:"encoding"`
+	LazyQuotes bool   `json:"lazy_quotes,omitempty" yaml:"lazy_quotes"`
+}
+
+// AmountFormat describes how a source writes amounts. NegativeStyles accepts
+// "parentheses", "trailing_minus", "cr_negative" and "dr_negative"; a leading
+// minus sign is always understood.
+type AmountFormat struct {
+	DecimalSeparator  string   `json:"decimal_separator" yaml:"decimal_separator"`
+	GroupingSeparator string   `json:"grouping_separator" yaml:"grouping_separator"`
+	NegativeStyles    []string `json:"negative_styles" yaml:"negative_styles"`
+	StripSymbols      bool     `json:"strip_symbols" yaml:"strip_symbols"`
 }
 
 type NormalizedRecord struct {
-	Source      string `json:"source"`
-	Key         string `json:"key"`
-	ID          string `json:"id"`
-	Account     string `json:"account,omitempty"`
-	AmountCents int64  `json:"amount_cents"`
-	Currency    string `json:"currency"`
-	Timestamp   string `json:"timestamp,omitempty"`
+	Source           string `json:"source"`
+	Key              string `json:"key"`
+	ID               string `json:"id"`
+	Account          string `json:"account,omitempty"`
+	AmountMinor      int64  `json:"amount_minor"`
+	Currency         string `json:"currency"`
+	CurrencyExponent int    `json:"currency_exponent"`
+	Timestamp        string `json:"timestamp,omitempty"`
+	Reference        string `json:"reference,omitempty"`
+	// File and Line locate the row the record was read from; File is
+	// relative to the engine input's directory.
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+
+	Rounding *RoundingDecision `json:"rounding,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty"`
+
+	OriginalCurrency    string `json:"original_currency,omitempty"`
+	OriginalAmountMinor *int64 `json:"original_amount_minor,omitempty"`
+	FXRate              string `json:"fx_rate,omitempty"`
+	// FXRounding records the rounding of the converted amount, with the exact
+	// converted value in the reporting currency as its raw value.
+	FXRounding *RoundingDecision `json:"fx_rounding,omitempty"`
+
+	ordinal int
+}
+
+// RoundingDecision records how a record's amount was rounded when rounding
+// changed the parsed or converted value.
+type RoundingDecision struct {
+	Mode                string `json:"mode"`
+	IncrementMinorUnits int64  `json:"increment_minor_units"`
+	RawValue            string `json:"raw_value"`
 }
 
 type NormalizationSummary struct {
-	RecordsProcessed int      `json:"records_processed"`
-	RecordsSkipped   int      `json:"records_skipped"`
-	Warnings         []string `json:"warnings"`
+	RecordsProcessed int            `json:"records_processed"`
+	RecordsSkipped   int            `json:"records_skipped"`
+	Warnings         []string       `json:"warnings"`
+	ParseWarnings    []ParseWarning `json:"parse_warnings"`
+}
+
+// RejectedRow is an input row that could not become a normalized record,
+// written to evidence/rejected.jsonl. File is relative to the engine input
+// file, and Line is omitted for formats without line numbers.
+type RejectedRow struct {
+	Source string `json:"source"`
+	File   string `json:"file"`
+	Line   int    `json:"line,omitempty"`
+	Raw    string `json:"raw"`
+	Reason string `json:"reason"`
+}
+
+type ParseWarning struct {
+	Source   string `json:"source"`
+	RecordID string `json:"record_id"`
+	Field    string `json:"field"`
+	RawValue string `json:"raw_value"`
+	Message  string `json:"message"`
 }
 
 type VarianceSummary struct {
+	Total        int                               `json:"total"`
+	CountsByType map[string]int                    `json:"counts_by_type"`
+	ByCurrency   map[string]CurrencyVarianceTotals `json:"by_currency"`
+}
+
+type CurrencyVarianceTotals struct {
+	Count                           int   `json:"count"`
+	TotalAbsoluteVarianceMinorUnits int64 `json:"total_absolute_variance_minor_units"`
+}
+
+type MatchSummary struct {
 	Total        int            `json:"total"`
 	CountsByType map[string]int `json:"counts_by_type"`
 }
@@ -68,7 +246,9 @@ type EvidenceManifest struct {
 	GeneratedAt   time.Time      `json:"generated_at"`
 	ToolVersion   string         `json:"tool_version"`
 	SchemaVersion string         `json:"schema_version"`
+	RulesetSHA256 string         `json:"ruleset_sha256"`
 	Files         []ManifestFile `json:"files"`
+	Inputs        []ManifestFile `json:"inputs,omitempty"`
 }
 
 type ManifestFile struct {
@@ -83,6 +263,9 @@ type EngineOutput struct {
 	NormalizationSummary   NormalizationSummary `json:"normalization_summary"`
 	VarianceSummary        VarianceSummary      `json:"variance_summary"`
 	VarianceItemsPath      string               `json:"variance_items_path"`
+	MatchSummary           MatchSummary         `json:"match_summary"`
+	MatchItemsPath         string               `json:"match_items_path"`
+	RejectedRowsPath       string               `json:"rejected_rows_path"`
 	EvidenceManifest       EvidenceManifest     `json:"evidence_manifest"`
 	DeterministicStatement string               `json:"deterministic_statement"`
 }
@@ -93,9 +276,53 @@ type VarianceItem struct {
 	Currency        string         `json:"currency"`
 	AmountsBySource []SourceAmount `json:"amounts_by_source,omitempty"`
 	MissingSources  []string       `json:"missing_sources,omitempty"`
+
+	ToleranceMinorUnits *int64   `json:"tolerance_minor_units,omitempty"`
+	MatchedKeys         []string `json:"matched_keys,omitempty"`
+	DateDeltaDays       *int     `json:"date_delta_days,omitempty"`
+
+	DuplicateRecords []SourceRecords   `json:"duplicate_records,omitempty"`
+	FieldDifferences []FieldDifference `json:"field_differences,omitempty"`
+}
+
+type FieldDifference struct {
+	Field          string        `json:"field"`
+	ValuesBySource []SourceValue `json:"values_by_source"`
+}
+
+type SourceValue struct {
+	Source string `json:"source"`
+	Value  string `json:"value"`
+}
+
+type MatchItem struct {
+	Key             string          `json:"key"`
+	Type            string          `json:"type"`
+	Currency        string          `json:"currency"`
+	Confidence      float64         `json:"confidence"`
+	Reference       string          `json:"reference,omitempty"`
+	AnchorSource    string          `json:"anchor_source,omitempty"`
+	MatchedKeys     []string        `json:"matched_keys"`
+	RecordsBySource []SourceRecords `json:"records_by_source"`
+	AmountsBySource []SourceAmount  `json:"amounts_by_source"`
+	DateDeltaDays   *int            `json:"date_delta_days,omitempty"`
+
+	ToleranceMinorUnits *int64 `json:"tolerance_minor_units,omitempty"`
+}
+
+type SourceRecords struct {
+	Source    string   `json:"source"`
+	RecordIDs []string `json:"record_ids"`
 }
 
 type SourceAmount struct {
-	Source      string `json:"source"`
-	AmountCents int64  `json:"amount_cents"`
+	Source          string           `json:"source"`
+	Currency        string           `json:"currency,omitempty"`
+	AmountMinor     int64            `json:"amount_minor"`
+	OriginalAmounts []CurrencyAmount `json:"original_amounts,omitempty"`
+}
+
+type CurrencyAmount struct {
+	Currency    string `json:"currency"`
+	AmountMinor int64  `json:"amount_minor"`
 }
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xlsxWorkbook is the parsed xl/workbook.xml of an Excel file.
+type xlsxWorkbook struct {
+	Properties struct {
+		Date1904 bool `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		ID   string `xml:"id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Type   string `xml:"Type,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxStyles struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+// xlsxSheet is the worksheet being read and what is needed to turn its cells
+// into text: shared strings, which cell styles are dates, and the workbook's
+// date system.
+type xlsxSheet struct {
+	file          *zip.File
+	sharedStrings []string
+	dateStyles    map[int]bool
+	date1904      bool
+}
+
+func validateXLSXOptions(options *XLSXOptions) error {
+	if options == nil {
+		return nil
+	}
+	if options.SheetIndex < 0 {
+		return errors.New("xlsx sheet_index must not be negative")
+	}
+	if options.HeaderRow < 0 {
+		return errors.New("xlsx header_row must not be negative")
+	}
+	return nil
+}
+
+// readXLSX streams the rows of one worksheet as records keyed by the header
+// row. Numeric cells are written as plain decimals at Excel's 15 significant
+// digits, and cells styled as dates become "2006-01-02" or
+// "2006-01-02 15:04:05". Excel stores dates without a timezone, so the text
+// is the wall-clock value shown in the workbook, and timestamp normalization
+// later reads it in the run timezone.
+func readXLSX(reader io.ReaderAt, size int64, options *XLSXOptions, fn func(inputRow) error) error {
+	if options == nil {
+		options = &XLSXOptions{}
+	}
+	archive, err := zip.NewReader(reader, size)
+	if err != nil {
+		return fmt.Errorf("read xlsx: %w", err)
+	}
+	sheet, err := openXLSXSheet(archive, options)
+	if err != nil {
+		return fmt.Errorf("read xlsx: %w", err)
+	}
+
+	var headers []string
+	return sheet.rows(func(number int, cells []string) error {
+		if number <= options.HeaderRow {
+			return nil
+		}
+		if headers == nil {
+			if number != options.HeaderRow+1 {
+				return fmt.Errorf("read xlsx: header row %d is empty", options.HeaderRow+1)
+			}
+			headers = make([]string, len(cells))
+			for index, cell := range cells {
+				headers[index] = strings.TrimSpace(cell)
+			}
+			return nil
+		}
+		input := inputRow{line: number, raw: csvRowText(cells, ','), fields: make(map[string]string, len(headers))}
+		for index, header := range headers {
+			if header == "" {
+				continue
+			}
+			value := ""
+			if index < len(cells) {
+				value = strings.TrimSpace(cells[index])
+			}
+			input.fields[header] = value
+		}
+		// Excel drops trailing empty cells, so only a value beyond the
+		// header is a width mismatch.
+		for index := len(headers); index < len(cells); index++ {
+			if strings.TrimSpace(cells[index]) != "" {
+				input.reject = fmt.Sprintf("expected %d fields, got %d", len(headers), index+1)
+			}
+		}
+		return fn(input)
+	})
+}
+
+func openXLSXSheet(archive *zip.Reader, options *XLSXOptions) (*xlsxSheet, error) {
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	var workbook xlsxWorkbook
+	if err := decodeXLSXPart(files, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	var relationships xlsxRelationships
+	if err := decodeXLSXPart(files, "xl/_rels/workbook.xml.rels", &relationships); err != nil {
+		return nil, err
+	}
+	targets := map[string]string{}
+	byType := map[string]string{}
+	for _, relationship := range relationships.Relationships {
+		target := relationship.Target
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join("xl", target)
+		}
+		targets[relationship.ID] = target
+		byType[path.Base(relationship.Type)] = target
+	}
+
+	if len(workbook.Sheets) == 0 {
+		return nil, errors.New("workbook has no sheets")
+	}
+	selected := -1
+	if options.Sheet != "" {
+		for index, candidate := range workbook.Sheets {
+			if candidate.Name == options.Sheet {
+				selected = index
+			}
+		}
+		if selected < 0 {
+			return nil, fmt.Errorf("sheet %q not found", options.Sheet)
+		}
+	} else {
+		if options.SheetIndex >= len(workbook.Sheets) {
+			return nil, fmt.Errorf("sheet_index %d out of range: workbook has %d sheets", options.SheetIndex, len(workbook.Sheets))
+		}
+		selected = options.SheetIndex
+	}
+	sheetFile := files[targets[workbook.Sheets[selected].ID]]
+	if sheetFile == nil {
+		return nil, fmt.Errorf("sheet %q has no worksheet part", workbook.Sheets[selected].Name)
+	}
+
+	sheet := &xlsxSheet{file: sheetFile, dateStyles: map[int]bool{}, date1904: workbook.Properties.Date1904}
+	if name, ok := byType["sharedStrings"]; ok && files[name] != nil {
+		sharedStrings, err := readSharedStrings(files[name])
+		if err != nil {
+			return nil, err
+		}
+		sheet.sharedStrings = sharedStrings
+	}
+	if name, ok := byType["styles"]; ok && files[name] != nil {
+		var styles xlsxStyles
+		if err := decodeXLSXPart(files, name, &styles); err != nil {
+			return nil, err
+		}
+		customFormats := map[int]string{}
+		for _, format := range styles.NumFmts {
+			customFormats[format.ID] = format.Code
+		}
+		for index, xf := range styles.CellXfs {
+			if code, ok := customFormats[xf.NumFmtID]; ok {
+				sheet.dateStyles[index] = isDateFormatCode(code)
+			} else {
+				sheet.dateStyles[index] = isBuiltinDateFormat(xf.NumFmtID)
+			}
+		}
+	}
+	return sheet, nil
+}
+
+func decodeXLSXPart(files map[string]*zip.File, name string, target any) error {
+	file := files[name]
+	if file == nil {
+		return fmt.Errorf("missing %s", name)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("open %s: %w", name, err)
+	}
+	defer reader.Close()
+	if err := xml.NewDecoder(reader).Decode(target); err != nil {
+		return fmt.Errorf("parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// readSharedStrings reads the shared string table, joining the runs of rich
+// text entries and leaving out phonetic hints.
+func readSharedStrings(file *zip.File) ([]string, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", file.Name, err)
+	}
+	defer reader.Close()
+
+	values := make([]string, 0)
+	var current []byte
+	inText, phonetic := false, 0
+	decoder := xml.NewDecoder(reader)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return values, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", file.Name, err)
+		}
+		switch typed := token.(type) {
+		case xml.StartElement:
+			switch typed.Name.Local {
+			case "si":
+				current = current[:0]
+			case "rPh":
+				phonetic++
+			case "t":
+				inText = phonetic == 0
+			}
+		case xml.EndElement:
+			switch typed.Name.Local {
+			case "si":
+				values = append(values, string(current))
+			case "rPh":
+				phonetic--
+			case "t":
+				inText = false
+			}
+		case xml.CharData:
+			if inText {
+				current = append(current, typed...)
+			}
+		}
+	}
+}
+
+// rows calls fn with the 1-based row number and cell text of every row that
+// has at least one non-empty cell, streaming the worksheet XML.
+func (sheet *xlsxSheet) rows(fn func(int, []string) error) error {
+	reader, err := sheet.file.Open()
+	if err != nil {
+		return fmt.Errorf("open %s: %w", sheet.file.Name, err)
+	}
+	defer reader.Close()
+
+	decoder := xml.NewDecoder(reader)
+	var cells []string
+	rowNumber, column := 0, 0
+	var cellType, cellStyle string
+	var value []byte
+	inValue, phonetic := false, 0
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", sheet.file.Name, err)
+		}
+		switch typed := token.(type) {
+		case xml.StartElement:
+			switch typed.Name.Local {
+			case "row":
+				rowNumber++
+				if number, err := strconv.Atoi(xmlAttr(typed, "r")); err == nil {
+					rowNumber = number
+				}
+				cells, column = cells[:0], 0
+			case "c":
+				if index, ok := columnIndex(xmlAttr(typed, "r")); ok {
+					column = index
+				}
+				cellType, cellStyle = xmlAttr(typed, "t"), xmlAttr(typed, "s")
+				value = value[:0]
+			case "v":
+				inValue = true
+			case "t":
+				inValue = phonetic == 0
+			case "rPh":
+				phonetic++
+			}
+		case xml.EndElement:
+			switch typed.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "rPh":
+				phonetic--
+			case "c":
+				text, err := sheet.cellText(cellType, cellStyle, string(value))
+				if err != nil {
+					return fmt.Errorf("%s cell row %d column %d: %w", sheet.file.Name, rowNumber, column+1, err)
+				}
+				for len(cells) <= column {
+					cells = append(cells, "")
+				}
+				cells[column] = text
+				column++
+			case "row":
+				if !blankCells(cells) {
+					if err := fn(rowNumber, append([]string(nil), cells...)); err != nil {
+						return err
+					}
+				}
+			}
+		case xml.CharData:
+			if inValue {
+				value = append(value, typed...)
+			}
+		}
+	}
+}
+
+func (sheet *xlsxSheet) cellText(cellType string, style string, value string) (string, error) {
+	switch cellType {
+	case "s":
+		index, err := strconv.Atoi(value)
+		if err != nil || index < 0 || index >= len(sheet.sharedStrings) {
+			return "", fmt.Errorf("invalid shared string index %q", value)
+		}
+		return sheet.sharedStrings[index], nil
+	case "b":
+		return strconv.FormatBool(value == "1"), nil
+	case "str", "inlineStr", "e", "d":
+		return value, nil
+	}
+	if value == "" {
+		return "", nil
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid number %q", value)
+	}
+	if styleIndex, err := strconv.Atoi(style); err == nil && sheet.dateStyles[styleIndex] {
+		return excelSerialTime(number, sheet.date1904), nil
+	}
+	// Excel keeps 15 significant digits; anything beyond is binary noise
+	// such as 1234.5600000000001.
+	return plainDecimal(strconv.FormatFloat(number, 'g', 15, 64)), nil
+}
+
+// excelSerialTime converts an Excel date serial into wall-clock date or
+// date-time text without a timezone, rounded to the second.
+func excelSerialTime(serial float64, date1904 bool) string {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	} else if serial < 61 {
+		// Serials before March 1900 count the fictitious 29 February 1900
+		// that Excel inherited from Lotus 1-2-3.
+		epoch = epoch.AddDate(0, 0, 1)
+	}
+	seconds := int64(math.Round(serial * 86400))
+	moment := epoch.Add(time.Duration(seconds) * time.Second)
+	if seconds%86400 == 0 {
+		return moment.Format("2006-01-02")
+	}
+	return moment.Format("2006-01-02 15:04:05")
+}
+
+func isBuiltinDateFormat(id int) bool {
+	return (id >= 14 && id <= 22) || (id >= 27 && id <= 36) || (id >= 45 && id <= 47) || (id >= 50 && id <= 58)
+}
+
+// isDateFormatCode reports whether a custom number format displays a date or
+// time, ignoring quoted literals, escaped characters, fill and padding
+// directives, and bracketed sections other than elapsed-time codes.
+func isDateFormatCode(code string) bool {
+	for index := 0; index < len(code); index++ {
+		switch char := code[index]; char {
+		case '"':
+			for index++; index < len(code) && code[index] != '"'; index++ {
+			}
+		case '\\', '_', '*':
+			index++
+		case '[':
+			end := strings.IndexByte(code[index:], ']')
+			if end < 0 {
+				return false
+			}
+			section := strings.ToLower(code[index+1 : index+end])
+			if strings.Trim(section, "hms") == "" && section != "" {
+				return true
+			}
+			index += end
+		case 'y', 'Y', 'm', 'M', 'd', 'D', 'h', 'H', 's', 'S':
+			return true
+		}
+	}
+	return false
+}
+
+// columnIndex converts the letters of a cell reference such as "BC12" into a
+// zero-based column index.
+func columnIndex(reference string) (int, bool) {
+	index := 0
+	letters := 0
+	for _, char := range reference {
+		if char >= 'A' && char <= 'Z' {
+			index = index*26 + int(char-'A') + 1
+			letters++
+			continue
+		}
+		break
+	}
+	if letters == 0 {
+		return 0, false
+	}
+	return index - 1, true
+}
+
+func xmlAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func blankCells(cells []string) bool {
+	for _, cell := range cells {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+)
+
+// buildXLSX assembles a minimal workbook from raw worksheet XML so tests do
+// not depend on binary fixtures.
+func buildXLSX(t *testing.T, sheets map[string]string, sharedStrings string, styles string) *bytes.Reader {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	write := func(name string, content string) {
+		writer, err := archive.Create(name)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		if _, err := writer.Write([]byte(content)); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	workbook := `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`
+	rels := `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`
+	for index, name := range []string{"Summary", "Transactions"} {
+		id := string(rune('1' + index))
+		workbook += `<sheet name="` + name + `" sheetId="` + id + `" r:id="rId` + id + `"/>`
+		rels += `<Relationship Id="rId` + id + `" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet` + id + `.xml"/>`
+		write("xl/worksheets/sheet"+id+".xml", `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`+sheets[name]+`</sheetData></worksheet>`)
+	}
+	workbook += `</sheets></workbook>`
+	rels += `<Relationship Id="rId8" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/sharedStrings" Target="sharedStrings.xml"/>`
+	rels += `<Relationship Id="rId9" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="/xl/styles.xml"/>`
+	rels += `</Relationships>`
+	write("xl/workbook.xml", workbook)
+	write("xl/_rels/workbook.xml.rels", rels)
+	write("xl/sharedStrings.xml", sharedStrings)
+	write("xl/styles.xml", styles)
+	if err := archive.Close(); err != nil {
+		t.Fatalf("close xlsx: %v", err)
+	}
+	return bytes.NewReader(buffer.Bytes())
+}
+
+func TestReadXLSX(t *testing.T) {
+	sharedStrings := `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<si><t>Statement March 2024</t></si>` +
+		`<si><t>Booking</t></si><si><t>Amount</t></si><si><t>Date</t></si><si><t>Posted</t></si><si><t>Memo</t></si>` +
+		`<si><r><t>Coffee </t></r><r><rPr><b/></rPr><t>beans</t></r><rPh><t>ignored</t></rPh></si>` +
+		`</sst>`
+	styles := `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy\-mm\-dd\ hh:mm"/></numFmts>` +
+		`<cellXfs count="4"><xf numFmtId="0"/><xf numFmtId="14"/><xf numFmtId="164"/><xf numFmtId="4"/></cellXfs>` +
+		`</styleSheet>`
+	transactions := `<row r="1"><c r="A1" t="s"><v>0</v></c></row>` +
+		`<row r="3"><c r="A3" t="s"><v>1</v></c><c r="B3" t="s"><v>2</v></c><c r="C3" t="s"><v>3</v></c><c r="D3" t="s"><v>4</v></c><c r="E3" t="s"><v>5</v></c></row>` +
+		`<row r="4"><c r="A4"><v>1001</v></c><c r="B4" s="3"><v>1234.5600000000001</v></c><c r="C4" s="1"><v>45352</v></c><c r="D4" s="2"><v>45352.75</v></c><c r="E4" t="s"><v>6</v></c></row>` +
+		`<row r="5"><c r="A5" t="inlineStr"><is><t>1002</t></is></c><c r="B5"><v>0.30000000000000004</v></c><c r="E5" t="b"><v>1</v></c></row>` +
+		`<row r="6"><c r="B6"><v></v></c></row>` +
+		`<row r="7"><c r="A7" t="str"><f>A5+1</f><v>1003</v></c><c r="B7"><v>-1.5E-3</v></c></row>` +
+		`<row r="8"><c r="A8"><v>1004</v></c><c r="B8"><v>2</v></c><c r="G8" t="inlineStr"><is><t>stray</t></is></c></row>` +
+		`<row r="9"><c r="A9"><v>1005</v></c><c r="B9"><v>3</v></c><c r="F9"><v></v></c></row>`
+	reader := buildXLSX(t, map[string]string{"Summary": `<row r="1"><c r="A1"><v>1</v></c></row>`, "Transactions": transactions}, sharedStrings, styles)
+
+	rows := make([]inputRow, 0)
+	err := readXLSX(reader, reader.Size(), &XLSXOptions{Sheet: "Transactions", HeaderRow: 2}, func(row inputRow) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("read xlsx: %v", err)
+	}
+	if len(rows) != 5 {
+		t.Fatalf("expected 5 rows, got %+v", rows)
+	}
+	first := rows[0].fields
+	if rows[0].line != 4 || first["Booking"] != "1001" || first["Amount"] != "1234.56" || first["Date"] != "2024-03-01" || first["Posted"] != "2024-03-01 18:00:00" || first["Memo"] != "Coffee beans" {
+		t.Fatalf("unexpected first row: line %d %v", rows[0].line, first)
+	}
+	if second := rows[1].fields; second["Booking"] != "1002" || second["Amount"] != "0.3" || second["Date"] != "" || second["Memo"] != "true" {
+		t.Fatalf("unexpected second row: %v", second)
+	}
+	if third := rows[2]; third.line != 7 || third.fields["Booking"] != "1003" || third.fields["Amount"] != "-0.0015" {
+		t.Fatalf("unexpected third row: line %d %v", third.line, third.fields)
+	}
+	if wide := rows[3]; wide.line != 8 || wide.reject != "expected 5 fields, got 7" {
+		t.Fatalf("row wider than the header should be rejected: %+v", wide)
+	}
+	if blank := rows[4]; blank.reject != "" || blank.fields["Booking"] != "1005" {
+		t.Fatalf("empty cells past the header should be ignored: %+v", blank)
+	}
+
+	reader = buildXLSX(t, map[string]string{"Summary": "", "Transactions": transactions}, sharedStrings, styles)
+	err = readXLSX(reader, reader.Size(), &XLSXOptions{SheetIndex: 5}, func(inputRow) error { return nil })
+	if err == nil {
+		t.Fatalf("out of range sheet_index should fail")
+	}
+}
+
+func TestExcelSerialTime(t *testing.T) {
+	cases := []struct {
+		serial   float64
+		date1904 bool
+		want     string
+	}{
+		{45352, false, "2024-03-01"},
+		{45352.999999, false, "2024-03-02"},
+		{45352.5000001, false, "2024-03-01 12:00:00"},
+		{59, false, "1900-02-28"},
+		{61, false, "1900-03-01"},
+		{43890, true, "2024-03-01"},
+	}
+	for _, tc := range cases {
+		if got := excelSerialTime(tc.serial, tc.date1904); got != tc.want {
+			t.Fatalf("excelSerialTime(%v, %v): got %s want %s", tc.serial, tc.date1904, got, tc.want)
+		}
+	}
+
+	for code, want := range map[string]bool{
+		"yyyy-mm-dd":           true,
+		"[h]:mm:ss":            true,
+		"#,##0.00":             false,
+		`"Days "0`:             false,
+		"[Red]#,##0;[Blue]0":   false,
+		`_-* #,##0.00_-;\-* #`: false,
+		"General":              false,
+	} {
+		if got := isDateFormatCode(code); got != want {
+			t.Fatalf("isDateFormatCode(%q): got %v want %v", code, got, want)
+		}
+	}
+}